@@ -0,0 +1,354 @@
+package cache
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	lfutyped "github.com/creachadair/cache/lfu/typed"
+	lrutyped "github.com/creachadair/cache/lru/typed"
+)
+
+// An EvictionPolicy selects the replacement policy of a Cache.
+type EvictionPolicy int
+
+// The eviction policies supported by New.
+const (
+	LRU EvictionPolicy = iota // evict the least-recently used value
+	LFU                       // evict the least-frequently used value
+)
+
+// ParsePolicy returns the policy with the given name, such as "LRU" or "lfu".
+// Names are matched without regard to case.
+func ParsePolicy(name string) (EvictionPolicy, error) {
+	for _, p := range []EvictionPolicy{LRU, LFU} {
+		if strings.EqualFold(name, p.String()) {
+			return p, nil
+		}
+	}
+	return 0, fmt.Errorf("cache: unknown policy %q", name)
+}
+
+// MarshalText implements the encoding.TextMarshaler interface.  It reports an
+// error for an unknown policy.
+func (p EvictionPolicy) MarshalText() ([]byte, error) {
+	switch p {
+	case LRU, LFU:
+		return []byte(p.String()), nil
+	default:
+		return nil, fmt.Errorf("cache: unknown policy %v", p)
+	}
+}
+
+// UnmarshalText implements the encoding.TextUnmarshaler interface, using
+// ParsePolicy.  This allows a policy to be read from a configuration file, for
+// example with encoding/json.
+func (p *EvictionPolicy) UnmarshalText(text []byte) error {
+	q, err := ParsePolicy(string(text))
+	if err != nil {
+		return err
+	}
+	*p = q
+	return nil
+}
+
+func (p EvictionPolicy) String() string {
+	switch p {
+	case LRU:
+		return "LRU"
+	case LFU:
+		return "LFU"
+	default:
+		return fmt.Sprintf("EvictionPolicy(%d)", int(p))
+	}
+}
+
+// Cache is a string-keyed cache of values whose replacement policy is chosen
+// by an option to New, so that callers can switch policies without changing
+// their types or import paths.  A *Cache is safe for concurrent access by
+// multiple goroutines, unless it was created with the Unlocked option.  A nil
+// *Cache behaves as a cache with 0 capacity.
+//
+// The zero value of Cache is ready for use as an empty LRU cache with 0
+// capacity, which can be given a capacity later with SetCap.
+//
+// Cache provides the settings and operations common to all policies.  For
+// settings specific to one policy, use the lru or lfu package directly.  Values
+// that implement Admitter or Evicter are notified when they are stored in and
+// removed from the cache, as they are by those packages.
+//
+// A Cache is implemented by the typed cache of the package for its policy,
+// lru/typed or lfu/typed.  Panics from a Cache, such as for a value with a
+// negative size, come from that package, and their messages begin with "lru"
+// or "lfu" rather than "cache".
+type Cache struct {
+	policy EvictionPolicy
+	once   sync.Once
+	s      store // set by New, or on first use
+}
+
+// An Option is a configurable setting for a Cache.
+type Option func(*config)
+
+// config collects the settings of a Cache for New.
+type config struct {
+	policy EvictionPolicy
+	lru    []lrutyped.Option[string, Value]
+	lfu    []lfutyped.Option[string, Value]
+}
+
+// both returns an Option that adds lo to the options for an LRU cache and fo
+// to the options for an LFU cache.  Only the options for the selected policy
+// are used, so options need not be ordered after Policy.
+func both(lo lrutyped.Option[string, Value], fo lfutyped.Option[string, Value]) Option {
+	return func(c *config) { c.lru = append(c.lru, lo); c.lfu = append(c.lfu, fo) }
+}
+
+// Policy sets the replacement policy of the cache.  The default is LRU.
+func Policy(p EvictionPolicy) Option { return func(c *config) { c.policy = p } }
+
+// Name sets the name of the cache.  The name is included in panic messages
+// and in the String output of the cache, to distinguish among the caches of a
+// program.
+func Name(name string) Option {
+	return both(lrutyped.Name[string, Value](name), lfutyped.Name[string, Value](name))
+}
+
+// OnEvict causes f to be called with the id and value of each entry that is
+// removed from the cache, including values replaced by Put and removed by Drop
+// or Reset.  The function f is called while the cache lock is held, and must
+// not call methods of the cache.
+func OnEvict(f func(id string, v Value)) Option {
+	return both(lrutyped.OnEvict(f), lfutyped.OnEvict(f))
+}
+
+// LowWater sets the low watermark of the cache to n. When a Put must evict
+// values to make room, it evicts until the resident size including the new
+// value is at most n, rather than only until the new value fits.  The capacity
+// acts as the high watermark; if n ≤ 0 or n is greater than the capacity, it
+// has no effect.
+func LowWater(n int) Option {
+	return both(lrutyped.LowWater[string, Value](n), lfutyped.LowWater[string, Value](n))
+}
+
+// CloseOnEvict causes the cache to call the Close method of each value that
+// implements io.Closer when it is removed from the cache.  If Close reports an
+// error and onError != nil, onError is called with the value and the error.
+func CloseOnEvict(onError func(Value, error)) Option {
+	return both(lrutyped.CloseOnEvict[string](onError), lfutyped.CloseOnEvict[string](onError))
+}
+
+// OnNegativeSize causes f to be called with the id and value when a Put or
+// Resize finds a value whose Size method reports a negative size, instead of
+// panicking.  The value is rejected.  The function f must not call methods of
+// the cache.
+func OnNegativeSize(f func(id string, v Value)) Option {
+	return both(lrutyped.OnNegativeSize(f), lfutyped.OnNegativeSize(f))
+}
+
+// ClampSize causes the cache to treat any value whose Size method reports
+// more than n as having size n.  If n ≤ 0, sizes are not clamped; this is the
+// default.
+func ClampSize(n int) Option {
+	return both(lrutyped.ClampSize[string, Value](n), lfutyped.ClampSize[string, Value](n))
+}
+
+// Admit causes f to be consulted before a new key is inserted into the cache.
+// If f returns false, the value is not stored.  The function f is called while
+// the cache lock is held, and must not call methods of the cache.
+func Admit(f func(id string, v Value) bool) Option {
+	return both(lrutyped.Admit(f), lfutyped.Admit(f))
+}
+
+// Validate causes f to be consulted when Get finds a resident value.  If f
+// returns false, the value is evicted and Get reports a miss.  The function f
+// is called while the cache lock is held, and must not call methods of the
+// cache.
+func Validate(f func(id string, v Value) bool) Option {
+	return both(lrutyped.Validate(f), lfutyped.Validate(f))
+}
+
+// Unlocked disables locking in the cache.  An unlocked *Cache is not safe for
+// concurrent use by multiple goroutines.
+func Unlocked() Option {
+	return both(lrutyped.Unlocked[string, Value](), lfutyped.Unlocked[string, Value]())
+}
+
+// CheckSize causes the cache to call the Size method of each value again when
+// it is evicted, and to compare the result with the size recorded when the
+// value was stored or last resized.  If they differ, f is called with the id
+// of the value and the recorded and current sizes; if f == nil, the cache
+// panics instead.
+func CheckSize(f func(id string, recorded, current int)) Option {
+	return both(lrutyped.CheckSize[string, Value](f), lfutyped.CheckSize[string, Value](f))
+}
+
+// New returns a new empty cache with the specified capacity.  New panics if
+// the options select an unknown policy.
+func New(capacity int, opts ...Option) *Cache {
+	var cfg config
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	c := &Cache{policy: cfg.policy}
+	switch cfg.policy {
+	case LRU:
+		c.s = newLRU(capacity, cfg.lru)
+	case LFU:
+		c.s = newLFU(capacity, cfg.lfu)
+	default:
+		panic(fmt.Sprintf("cache: unknown policy %v", cfg.policy))
+	}
+	return c
+}
+
+// newLRU returns an LRU cache with the specified capacity and options, which
+// sizes its values and notifies them of their lifecycle as Value
+// implementations.
+func newLRU(capacity int, opts []lrutyped.Option[string, Value]) store {
+	return lrutyped.New(capacity, append([]lrutyped.Option[string, Value]{
+		lrutyped.SizeOf[string](Value.Size),
+		lrutyped.OnAdmit[string](admitted),
+		lrutyped.OnRelease[string](released),
+	}, opts...)...)
+}
+
+// newLFU returns an LFU cache like newLRU.
+func newLFU(capacity int, opts []lfutyped.Option[string, Value]) store {
+	return lfutyped.New(capacity, append([]lfutyped.Option[string, Value]{
+		lfutyped.SizeOf[string](Value.Size),
+		lfutyped.OnAdmit[string](admitted),
+		lfutyped.OnRelease[string](released),
+	}, opts...)...)
+}
+
+// store is the interface of the typed caches that implement the policies.
+type store interface {
+	Put(string, Value)
+	Get(string) (Value, bool)
+	Drop(string) (Value, bool)
+	Rename(oldID, newID string) bool
+	Resize(string) bool
+	Audit() int
+	Len() int
+	Size() int
+	Name() string
+	Cap() int
+	SetCap(int)
+	Reset()
+	ResetQuiet()
+	Purge(float64)
+}
+
+// store returns the typed cache that implements c.  If c == nil, it returns a
+// nil cache, which behaves as a cache with 0 capacity.  The zero value of Cache
+// gets an LRU cache with 0 capacity on first use.
+func (c *Cache) store() store {
+	if c == nil {
+		return (*lrutyped.Cache[string, Value])(nil)
+	}
+	c.once.Do(func() {
+		if c.s == nil {
+			c.s = newLRU(0, nil)
+		}
+	})
+	return c.s
+}
+
+// Policy returns the replacement policy of c.
+func (c *Cache) Policy() EvictionPolicy {
+	if c == nil {
+		return LRU
+	}
+	return c.policy
+}
+
+// Put stores value into the cache under the given id.  Storing the value that
+// is already cached under id does not release it.
+func (c *Cache) Put(id string, value Value) { c.store().Put(id, value) }
+
+// Get returns the data associated with id in the cache, or nil if not present.
+func (c *Cache) Get(id string) Value {
+	v, _ := c.store().Get(id)
+	return v
+}
+
+// Drop discards the value stored in the cache for id, if any, and returns the
+// value discarded or nil.
+func (c *Cache) Drop(id string) Value {
+	v, _ := c.store().Drop(id)
+	return v
+}
+
+// Rename moves the value stored under oldID so that it is stored under newID
+// instead, preserving its standing under the replacement policy.  If a value
+// was already stored under newID, it is evicted.  Rename reports whether a
+// value was stored under oldID.
+func (c *Cache) Rename(oldID, newID string) bool { return c.store().Rename(oldID, newID) }
+
+// Resize updates the recorded size of the value stored under id by calling its
+// Size method again, evicting other values as needed to make room for it.  If
+// it no longer fits in the cache at all, it is evicted.  Resize reports whether
+// id is resident after the update.
+func (c *Cache) Resize(id string) bool { return c.store().Resize(id) }
+
+// Audit calls the Size method of each resident value and reports the total
+// difference between the current sizes and the sizes recorded by the cache.
+func (c *Cache) Audit() (drift int) { return c.store().Audit() }
+
+// Len returns the number of values resident in the cache.
+func (c *Cache) Len() int { return c.store().Len() }
+
+// Size returns the total size of the values resident in the cache.
+func (c *Cache) Size() int { return c.store().Size() }
+
+// Name returns the name of c, or "" if it has no name.
+func (c *Cache) Name() string { return c.store().Name() }
+
+// Cap returns the capacity of the cache.
+func (c *Cache) Cap() int { return c.store().Cap() }
+
+// SetCap sets the capacity of c to n, evicting values as necessary to fit
+// within the new capacity.  If n ≤ 0, all values are evicted.  SetCap has no
+// effect on a nil *Cache.
+func (c *Cache) SetCap(n int) { c.store().SetCap(n) }
+
+// Reset removes all values from the cache.  This operation does not change the
+// capacity of the cache.
+func (c *Cache) Reset() { c.store().Reset() }
+
+// ResetQuiet removes all values from the cache without calling the eviction
+// handler for the values removed.  Values that implement Evicter are still
+// notified, and values are still closed if the cache was created with
+// CloseOnEvict.
+func (c *Cache) ResetQuiet() { c.store().ResetQuiet() }
+
+// Purge evicts the fraction frac of the values currently stored in c, in the
+// order given by the replacement policy.  A fraction ≤ 0 evicts nothing; a
+// fraction ≥ 1 evicts everything, like Reset.
+func (c *Cache) Purge(frac float64) { c.store().Purge(frac) }
+
+// String returns a brief human-readable summary of the occupancy of c.
+func (c *Cache) String() string {
+	s := c.store()
+	if name := s.Name(); name != "" {
+		return fmt.Sprintf("cache.Cache(name=%q, policy=%v, size=%d, cap=%d, len=%d)",
+			name, c.Policy(), s.Size(), s.Cap(), s.Len())
+	}
+	return fmt.Sprintf("cache.Cache(policy=%v, size=%d, cap=%d, len=%d)",
+		c.Policy(), s.Size(), s.Cap(), s.Len())
+}
+
+// admitted notifies v that it has been stored in a cache, if v supports it.
+func admitted(v Value) {
+	if a, ok := v.(Admitter); ok {
+		a.Admitted()
+	}
+}
+
+// released notifies v that it has been removed from a cache, if v supports it.
+func released(v Value) {
+	if e, ok := v.(Evicter); ok {
+		e.Evicted()
+	}
+}
@@ -0,0 +1,187 @@
+package cache
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestPolicies(t *testing.T) {
+	tests := []struct {
+		policy EvictionPolicy
+		victim string
+		size   int
+	}{
+		{LRU, "b", 5}, // b is least recently used
+		{LFU, "c", 4}, // c is least frequently used
+	}
+	for _, test := range tests {
+		t.Run(test.policy.String(), func(t *testing.T) {
+			var victims []string
+			c := New(5, Policy(test.policy), OnEvict(func(id string, _ Value) {
+				victims = append(victims, id)
+			}))
+			if p := c.Policy(); p != test.policy {
+				t.Errorf("Policy: got %v, want %v", p, test.policy)
+			}
+			c.Put("a", String("12"))
+			c.Put("b", String("3"))
+			c.Put("c", String("45"))
+			c.Get("b")
+			c.Get("b")
+			c.Get("a")
+			c.Get("a")
+			c.Get("c")
+
+			c.Put("d", String("6"))
+			if want := []string{test.victim}; !reflect.DeepEqual(victims, want) {
+				t.Errorf("Victims: got %q, want %q", victims, want)
+			}
+			if v := c.Get(test.victim); v != nil {
+				t.Errorf("Get(%q): got %v, want nil", test.victim, v)
+			}
+			if n, s := c.Len(), c.Size(); n != 3 || s != test.size {
+				t.Errorf("Len, Size: got %d, %d; want 3, %d", n, s, test.size)
+			}
+			if v := c.Drop("d"); v != String("6") {
+				t.Errorf("Drop(d): got %v, want %q", v, "6")
+			}
+			c.Reset()
+			if n := c.Size(); n != 0 {
+				t.Errorf("Size after Reset: got %d, want 0", n)
+			}
+		})
+	}
+}
+
+func TestParsePolicy(t *testing.T) {
+	for _, p := range []EvictionPolicy{LRU, LFU} {
+		for _, name := range []string{p.String(), strings.ToLower(p.String())} {
+			if got, err := ParsePolicy(name); err != nil || got != p {
+				t.Errorf("ParsePolicy(%q): got %v, %v; want %v, nil", name, got, err, p)
+			}
+		}
+	}
+	if got, err := ParsePolicy("MRU"); err == nil {
+		t.Errorf("ParsePolicy(MRU): got %v, want error", got)
+	}
+
+	// A policy can be read from and written to a configuration.
+	var cfg struct{ Policy EvictionPolicy }
+	if err := json.Unmarshal([]byte(`{"Policy": "lfu"}`), &cfg); err != nil {
+		t.Fatalf("Unmarshal: unexpected error: %v", err)
+	} else if cfg.Policy != LFU {
+		t.Errorf("Unmarshal: got %v, want LFU", cfg.Policy)
+	}
+	if got, err := json.Marshal(cfg); err != nil {
+		t.Errorf("Marshal: unexpected error: %v", err)
+	} else if want := `{"Policy":"LFU"}`; string(got) != want {
+		t.Errorf("Marshal: got %s, want %s", got, want)
+	}
+	if _, err := json.Marshal(EvictionPolicy(99)); err == nil {
+		t.Error("Marshal of an unknown policy: got nil, want error")
+	}
+	if err := json.Unmarshal([]byte(`"MRU"`), &cfg.Policy); err == nil {
+		t.Error("Unmarshal of an unknown policy: got nil, want error")
+	}
+}
+
+func TestUnknownPolicy(t *testing.T) {
+	defer func() {
+		if x := recover(); x == nil {
+			t.Error("New with an unknown policy did not panic")
+		}
+	}()
+	New(1, Policy(EvictionPolicy(99)))
+}
+
+func TestNilCache(t *testing.T) {
+	var c *Cache
+	c.Put("x", Nil) // shouldn't crash
+	if v := c.Get("x"); v != nil {
+		t.Errorf("Get(x): got %v, want nil", v)
+	}
+	if v := c.Drop("x"); v != nil {
+		t.Errorf("Drop(x): got %v, want nil", v)
+	}
+	if n := c.Cap(); n != 0 {
+		t.Errorf("Cap: got %d, want 0", n)
+	}
+	c.SetCap(10)
+	if c.Rename("x", "y") {
+		t.Error("Rename(x, y): got true, want false")
+	}
+	if got, want := c.String(), "cache.Cache(policy=LRU, size=0, cap=0, len=0)"; got != want {
+		t.Errorf("String: got %q, want %q", got, want)
+	}
+	c.Reset()
+}
+
+type logValue struct {
+	name string
+	log  *[]string
+}
+
+func (v logValue) Size() int { return 1 }
+func (v logValue) Admitted() { *v.log = append(*v.log, "+"+v.name) }
+func (v logValue) Evicted()  { *v.log = append(*v.log, "-"+v.name) }
+
+func TestLifecycle(t *testing.T) {
+	for _, p := range []EvictionPolicy{LRU, LFU} {
+		t.Run(p.String(), func(t *testing.T) {
+			var log []string
+			c := New(2, Policy(p))
+			c.Put("a", logValue{"a", &log})
+			c.Put("b", logValue{"b", &log})
+			c.Get("b")
+			c.Put("c", logValue{"c", &log}) // evicts a under either policy
+			c.Drop("b")
+			c.Reset()
+			if want := []string{"+a", "+b", "-a", "+c", "-b", "-c"}; !reflect.DeepEqual(log, want) {
+				t.Errorf("Lifecycle: got %q, want %q", log, want)
+			}
+		})
+	}
+}
+
+func TestZeroValue(t *testing.T) {
+	var c Cache
+	c.Put("x", String("abc")) // no capacity, not stored
+	if v := c.Get("x"); v != nil {
+		t.Errorf("Get(x): got %v, want nil", v)
+	}
+	if p := c.Policy(); p != LRU {
+		t.Errorf("Policy: got %v, want LRU", p)
+	}
+	c.SetCap(5)
+	c.Put("x", String("abc"))
+	if v := c.Get("x"); v != String("abc") {
+		t.Errorf("Get(x): got %v, want %q", v, "abc")
+	}
+	if got, want := c.String(), "cache.Cache(policy=LRU, size=3, cap=5, len=1)"; got != want {
+		t.Errorf("String: got %q, want %q", got, want)
+	}
+}
+
+func TestOptions(t *testing.T) {
+	for _, p := range []EvictionPolicy{LRU, LFU} {
+		t.Run(p.String(), func(t *testing.T) {
+			// Options apply regardless of whether they precede Policy.
+			c := New(10, Name("test"), Admit(func(id string, _ Value) bool {
+				return id != "no"
+			}), Policy(p))
+			c.Put("no", Nil)
+			c.Put("yes", Nil)
+			if n := c.Len(); n != 1 {
+				t.Errorf("Len: got %d, want 1", n)
+			}
+			if !c.Rename("yes", "ok") || c.Get("ok") == nil {
+				t.Error("Rename(yes, ok) did not move the value")
+			}
+			if name := c.Name(); name != "test" {
+				t.Errorf("Name: got %q, want test", name)
+			}
+		})
+	}
+}
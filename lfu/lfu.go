@@ -16,180 +16,357 @@
 package lfu
 
 import (
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
 	"sync"
 
 	"github.com/creachadair/cache"
+	"github.com/creachadair/cache/lfu/typed"
 )
 
 // Cache implements a string-keyed LFU cache of arbitrary values.  A *Cache is
-// safe for concurrent access by multiple goroutines.  A nil *Cache behaves as
-// a cache with 0 capacity.
+// safe for concurrent access by multiple goroutines, unless it was created
+// with the Unlocked option.  A nil *Cache behaves as a cache with 0 capacity.
+//
+// The zero value of Cache is ready for use as an empty cache with 0 capacity,
+// which can be given a capacity later with SetCap.
 type Cache struct {
-	μ       sync.Mutex
-	size    int            // resident size (invariant: size ≤ cap)
-	cap     int            // maximum capacity
-	heap    []*entry       // min-heap by frequency of use
-	res     map[string]int // resident blocks, id → heap-index
-	onEvict func(cache.Value)
+	once sync.Once
+	c    *typed.Cache[string, cache.Value]   // set by New, or on first use
+	opts []typed.Option[string, cache.Value] // collected by New
 }
 
 // An Option is a configurable setting for a cache.
 type Option func(*Cache)
 
+// with returns an Option that adds opt to the options of the underlying typed
+// cache.
+func with(opt typed.Option[string, cache.Value]) Option {
+	return func(c *Cache) { c.opts = append(c.opts, opt) }
+}
+
+// Name sets the name of the cache.  The name is included in panic messages
+// and in the String and Dump output of the cache, to distinguish among the
+// caches of a program.
+func Name(name string) Option { return with(typed.Name[string, cache.Value](name)) }
+
 // OnEvict causes f to be called whenever a value is evicted from the cache.
 // The value being evicted is passed to f.
-func OnEvict(f func(cache.Value)) Option { return func(c *Cache) { c.onEvict = f } }
+//
+// Calls to f are made synchronously while the cache lock is held, so they are
+// delivered in exactly the order the evictions occur. For the same reason, f
+// must not call methods of the cache.
+func OnEvict(f func(cache.Value)) Option {
+	if f == nil {
+		return with(typed.OnEvict[string, cache.Value](nil))
+	}
+	return with(typed.OnEvict(func(_ string, v cache.Value) { f(v) }))
+}
+
+// LowWater sets the low watermark of the cache to n. When a Put must evict
+// values to make room, it evicts until the resident size including the new
+// value is at most n, rather than only until the new value fits.  This trades
+// a larger eviction on one Put for fewer evictions on subsequent ones.  The
+// capacity acts as the high watermark; if n ≤ 0 or n is greater than the
+// capacity, it has no effect.
+func LowWater(n int) Option { return with(typed.LowWater[string, cache.Value](n)) }
+
+// InitialUses sets the use count of newly-inserted entries to n, instead of
+// the default of 1.  Setting n to 0 means that inserting a value does not count
+// as a use, which suits write-heavy workloads where many values are written
+// but never read.
+func InitialUses(n int) Option { return with(typed.InitialUses[string, cache.Value](n)) }
+
+// CountReplace causes a Put that replaces the value of an existing entry to
+// count as a use of that entry.  By default, replacement does not change the
+// use count.
+func CountReplace() Option { return with(typed.CountReplace[string, cache.Value]()) }
+
+// DynamicAging enables dynamic aging (LFU-DA) for the cache.  With aging, the
+// weight of an entry is its use count plus the weight of the most recent
+// victim at the time of its last use, rather than its use count alone.  This
+// lets newer entries displace entries that were heavily used in the past but
+// are no longer being used.
+func DynamicAging() Option { return with(typed.DynamicAging[string, cache.Value]()) }
+
+// CloseOnEvict causes the cache to call the Close method of each value that
+// implements io.Closer when it is removed from the cache, whether by eviction,
+// replacement, or a reset (including ResetQuiet).  If Close reports an error
+// and onError != nil, onError is called with the value and the error.  Close
+// is called while the cache lock is held, after any OnEvict handler.
+func CloseOnEvict(onError func(cache.Value, error)) Option {
+	return with(typed.CloseOnEvict[string](onError))
+}
+
+// OnNegativeSize causes f to be called with the id and value when a Put or
+// Resize finds a value whose Size method reports a negative size, instead of
+// panicking.  The value is rejected: a Put does not store it, and a Resize
+// evicts it.  The function f must not call methods of the cache.
+func OnNegativeSize(f func(id string, v cache.Value)) Option {
+	return with(typed.OnNegativeSize(f))
+}
+
+// ClampSize causes the cache to treat any value whose Size method reports
+// more than n as having size n.  This bounds the damage a value reporting an
+// absurd size can do to the accounting of the cache.  If n ≤ 0, sizes are
+// not clamped; this is the default.
+func ClampSize(n int) Option { return with(typed.ClampSize[string, cache.Value](n)) }
+
+// Admit causes f to be consulted before a new key is inserted into the cache.
+// If f returns false, the value is not stored.  Replacing the value of a key
+// that is already resident does not consult f.  This permits custom admission
+// control, such as a doorkeeper or sampling, without a separate policy.  The
+// function f is called while the cache lock is held, and must not call methods
+// of the cache.
+func Admit(f func(id string, v cache.Value) bool) Option {
+	return with(typed.Admit(f))
+}
+
+// Validate causes f to be consulted when Get finds a resident value.  If f
+// returns false, the value is evicted and Get reports a miss.  This permits
+// entries to be invalidated by cheap external checks, such as comparing a file
+// modification time or an ETag, without a separate expiry mechanism.  If such
+// checks are costly, f may skip them for recently-checked keys and return
+// true.  The function f is called while the cache lock is held, and must not
+// call methods of the cache.
+func Validate(f func(id string, v cache.Value) bool) Option {
+	return with(typed.Validate(f))
+}
+
+// Unlocked disables locking in the cache.  An unlocked *Cache is not safe for
+// concurrent use by multiple goroutines, but avoids the cost of the mutex in
+// pipelines where only one goroutine ever touches the cache.
+func Unlocked() Option { return with(typed.Unlocked[string, cache.Value]()) }
+
+// CheckSize causes the cache to call the Size method of each value again
+// when it is evicted, and to compare the result with the size recorded when
+// the value was stored or last resized.  If they differ, f is called with the
+// id of the value and the recorded and current sizes; if f == nil, the cache
+// panics instead.  This is meant as a debugging aid for finding values whose
+// size changes while they are cached.
+func CheckSize(f func(id string, recorded, current int)) Option {
+	return with(typed.CheckSize[string, cache.Value](f))
+}
 
 // New returns a new empty cache with the specified capacity.
 func New(capacity int, opts ...Option) *Cache {
-	c := &Cache{
-		cap: capacity,
-		res: make(map[string]int),
-	}
+	c := new(Cache)
 	for _, opt := range opts {
 		opt(c)
 	}
+	c.c = newCore(capacity, c.opts)
+	c.opts = nil
 	return c
 }
 
-// Put stores value into the cache under the given id.  A Put counts as a use
-// on first insertion, but not subsequently.
-func (c *Cache) Put(id string, value cache.Value) {
-	if c != nil && c.cap > 0 {
-		vsize := value.Size()
-		if vsize < 0 {
-			panic("negative value size")
-		} else if vsize > c.cap {
-			return // there is no room for this value no matter what
-		}
-		c.μ.Lock()
-		defer c.μ.Unlock()
-		pos, ok := c.res[id]
-		if !ok {
-			for c.size+vsize > c.cap {
-				c.evict()
-			}
-			c.add(id, value)
-			c.size += vsize
-			return
-		}
+// newCore returns a typed cache with the specified capacity and options, which
+// sizes its values and notifies them of their lifecycle as cache.Value
+// implementations.
+func newCore(capacity int, opts []typed.Option[string, cache.Value]) *typed.Cache[string, cache.Value] {
+	return typed.New(capacity, append([]typed.Option[string, cache.Value]{
+		typed.SizeOf[string](cache.Value.Size),
+		typed.OnAdmit[string](admitted),
+		typed.OnRelease[string](released),
+	}, opts...)...)
+}
 
-		// There is already an entry for this key.  Evict the existing value
-		// and replace it with the new one (but do not count this as a use).
-		cur := c.heap[pos]
-		if c.onEvict != nil {
-			c.onEvict(cur.value)
-		}
-		cur.value = value
+// core returns the underlying typed cache of c, or nil if c == nil.  The zero
+// value of Cache gets a cache with 0 capacity on first use.
+func (c *Cache) core() *typed.Cache[string, cache.Value] {
+	if c == nil {
+		return nil
 	}
+	c.once.Do(func() {
+		if c.c == nil {
+			c.c = newCore(0, nil)
+		}
+	})
+	return c.c
 }
 
+// Put stores value into the cache under the given id.  By default, a Put
+// counts as one use on first insertion, but not subsequently; see the
+// InitialUses and CountReplace options.  Storing the value that is already
+// cached under id does not release it: the value is not notified of eviction
+// or admission, and it is not closed.
+func (c *Cache) Put(id string, value cache.Value) { c.core().Put(id, value) }
+
 // Get returns the data associated with id in the cache, or nil if not present.
 func (c *Cache) Get(id string) cache.Value {
-	if c != nil {
-		c.μ.Lock()
-		defer c.μ.Unlock()
-		if pos, ok := c.res[id]; ok {
-			elt := c.heap[pos]
-			elt.uses++
-			c.fix(pos)
-			return elt.value
+	v, _ := c.core().Get(id)
+	return v
+}
+
+// Rename moves the value stored under oldID so that it is stored under newID
+// instead, preserving its use count. If a value was already stored under
+// newID, it is evicted. Rename reports whether a value was stored under oldID.
+func (c *Cache) Rename(oldID, newID string) bool { return c.core().Rename(oldID, newID) }
+
+// Resize updates the recorded size of the value stored under id by calling
+// its Size method again, for values whose size may change while they are
+// cached.  If the value has grown, other values are evicted as needed to make
+// room for it; if it no longer fits in the cache at all, it is evicted.
+// Resize does not count as a use.  It reports whether id is resident after
+// the update.
+func (c *Cache) Resize(id string) bool { return c.core().Resize(id) }
+
+// Info summarizes a single entry in the cache.
+type Info struct {
+	ID     string // the key of the entry
+	Size   int    // the recorded size of the value
+	Uses   int    // the number of uses of the entry
+	Weight int    // the eviction weight; equal to Uses without aging
+}
+
+// Frequencies returns a summary of the entries resident in the cache, in the
+// order they would be evicted, starting with the lowest weight.  Entries with
+// equal weights are in no particular order.  It does not count as a use of any
+// entry.
+func (c *Cache) Frequencies() []Info {
+	entries := c.core().Frequencies()
+	if entries == nil {
+		return nil
+	}
+	out := make([]Info, len(entries))
+	for i, e := range entries {
+		out[i] = Info(e)
+	}
+	return out
+}
+
+// KeysPage returns up to limit keys resident in c that are greater than or
+// equal to cursor, in lexicographic order, together with a cursor for the
+// next page.  Pass an empty cursor to begin, and stop when the returned next
+// cursor is empty.  If limit ≤ 0, all remaining keys are returned.  Pages do
+// not reflect a single snapshot of the cache: keys added or removed between
+// calls may or may not be reported.
+func (c *Cache) KeysPage(cursor string, limit int) (keys []string, next string) {
+	var more bool
+	c.core().Range(func(id string, _ cache.Value) bool {
+		if id < cursor {
+			return true
 		}
+		keys = append(keys, id)
+
+		// Discard keys that cannot be part of this page, to bound the space
+		// used when the cache is much larger than a page.
+		if limit > 0 && len(keys) >= 2*limit {
+			sort.Strings(keys)
+			keys, more = keys[:limit], true
+		}
+		return true
+	})
+
+	sort.Strings(keys)
+	if limit > 0 && len(keys) > limit {
+		keys, more = keys[:limit], true
 	}
-	return nil
+	if more {
+		next = keys[limit-1] + "\x00" // the least string greater than the last key
+	}
+	return keys, next
 }
 
+// Audit calls the Size method of each resident value and reports the total
+// difference between the current sizes and the sizes recorded by the cache,
+// positive if values have grown and negative if they have shrunk.  A nonzero
+// result means that values changed size without a call to Resize.  Audit
+// does not change the recorded sizes.  It may be called periodically to
+// detect drift in the accounting of long-lived caches.
+func (c *Cache) Audit() (drift int) { return c.core().Audit() }
+
 // Size returns the total size of all values currently resident in the cache.
-func (c *Cache) Size() int {
-	if c != nil {
-		c.μ.Lock()
-		defer c.μ.Unlock()
-		return c.size
+func (c *Cache) Size() int { return c.core().Size() }
+
+// String returns a brief human-readable summary of the occupancy of c.
+func (c *Cache) String() string {
+	size, n := c.Size(), c.core().Len()
+	if name := c.Name(); name != "" {
+		return fmt.Sprintf("lfu.Cache(name=%q, size=%d, cap=%d, len=%d)", name, size, c.Cap(), n)
 	}
-	return 0
+	return fmt.Sprintf("lfu.Cache(size=%d, cap=%d, len=%d)", size, c.Cap(), n)
 }
 
-// Cap returns the total capacity of the cache.
-func (c *Cache) Cap() int {
-	if c == nil {
-		return 0
+// Dump writes a human-readable description of the contents of c to w,
+// including its occupancy and each resident entry in eviction order, with
+// the lowest weight first.
+func (c *Cache) Dump(w io.Writer) error {
+	var buf bytes.Buffer
+	var size int
+	entries := c.Frequencies()
+	for _, e := range entries {
+		size += e.Size
+	}
+	if name := c.Name(); name != "" {
+		fmt.Fprintf(&buf, "cache %q: ", name)
 	}
-	return c.cap
+	fmt.Fprintf(&buf, "size %d/%d, %d entries\n", size, c.Cap(), len(entries))
+	for _, e := range entries {
+		fmt.Fprintf(&buf, "  %q size=%d uses=%d weight=%d\n", e.ID, e.Size, e.Uses, e.Weight)
+	}
+	_, err := w.Write(buf.Bytes())
+	return err
 }
 
-// Reset removes all data currently stored in c, leaving it empty.  This
-// operation does not change the capacity of c.
-func (c *Cache) Reset() {
-	if c != nil {
-		c.μ.Lock()
-		defer c.μ.Unlock()
-		for c.size > 0 {
-			c.evict()
-		}
-	}
+// Name returns the name of c, or "" if it has no name.
+func (c *Cache) Name() string { return c.core().Name() }
+
+// Cap returns the total capacity of the cache.
+func (c *Cache) Cap() int { return c.core().Cap() }
+
+// SetCap sets the capacity of c to n, evicting values as necessary to fit
+// within the new capacity.  If n ≤ 0, all values are evicted.  SetCap has no
+// effect on a nil *Cache.
+func (c *Cache) SetCap(n int) { c.core().SetCap(n) }
+
+// Reset removes all data currently stored in c, leaving it empty.  Values are
+// evicted in order, lowest weight first.  This operation does not change the
+// capacity of c.
+func (c *Cache) Reset() { c.core().Reset() }
+
+// Compact reallocates the internal storage of c to fit its current contents.
+// After a large number of values have been evicted, its index and heap may retain
+// the space needed for the peak occupancy; Compact releases that space to
+// the allocator.  It does not change the contents of c.
+func (c *Cache) Compact() { c.core().Compact() }
+
+// ResetBatched removes all data currently stored in c, like Reset, but in
+// batches of at most batch values, releasing the cache lock between batches so
+// that other operations can proceed.  Values are evicted lowest weight first.
+//
+// After each batch, if progress != nil, it is called with the total number of
+// values evicted so far and the number still resident.  If progress returns
+// false, ResetBatched stops early; calling it again resumes the reset.
+// ResetBatched reports whether the cache was empty when it returned.  Values
+// stored by other goroutines while the reset is in progress are also evicted.
+func (c *Cache) ResetBatched(batch int, progress func(evicted, remaining int) bool) bool {
+	return c.core().ResetBatched(batch, progress)
 }
 
-// entry represents a node in a min-heap by frequency of use.
-type entry struct {
-	id    string
-	value cache.Value
-	uses  int
-}
-
-// add inserts a new entry into the cache mapping id to value.  Assumes id is
-// not already resident, and that c.μ is held.
-func (c *Cache) add(id string, value cache.Value) {
-	pos := len(c.heap)
-	elt := &entry{id: id, value: value, uses: 1}
-	c.heap = append(c.heap, elt)
-	for pos > 0 {
-		par := pos / 2
-		if up := c.heap[par]; up.uses > 1 {
-			c.heap[par] = elt
-			c.heap[pos] = up
-			c.res[up.id] = pos
-			pos = par
-			continue
-		}
-		break
+// ResetQuiet removes all data currently stored in c, leaving it empty, without
+// calling the eviction handler for the values removed.  Values that implement
+// cache.Evicter are still notified, and values are still closed if the cache
+// was created with CloseOnEvict.  This operation does not change the capacity
+// of c.
+func (c *Cache) ResetQuiet() { c.core().ResetQuiet() }
+
+// Purge evicts the fraction frac of the entries currently stored in c, lowest
+// weight first, calling the eviction handler for each.  A fraction ≤ 0 evicts
+// nothing; a fraction ≥ 1 evicts everything, like Reset.
+func (c *Cache) Purge(frac float64) { c.core().Purge(frac) }
+
+// admitted notifies v that it has been stored in a cache, if v supports it.
+func admitted(v cache.Value) {
+	if a, ok := v.(cache.Admitter); ok {
+		a.Admitted()
 	}
-	c.res[id] = pos
 }
 
-// evict removes the least-frequently used element from the cache, calling the
-// eviction handler if necessary for its value.  Assumes that c.μ is held.
-func (c *Cache) evict() {
-	vic := c.heap[0]
-	if c.onEvict != nil {
-		c.onEvict(vic.value)
-	}
-	delete(c.res, vic.id)
-	n := len(c.heap) - 1
-	c.heap[0] = c.heap[n]
-	c.heap = c.heap[:n]
-	c.fix(0)
-	c.size -= vic.value.Size()
-}
-
-// fix restores heap order to c.heap at or below pos, assuming that the weight
-// of pos has remained the same or increased.  Assumes c.μ is held.
-func (c *Cache) fix(pos int) {
-	for {
-		mc := 2 * pos
-		if mc >= len(c.heap) {
-			return
-		} else if rc := mc + 1; rc < len(c.heap) && c.heap[rc].uses < c.heap[mc].uses {
-			mc = rc
-		}
-		cur := c.heap[pos]
-		min := c.heap[mc]
-		if cur.uses <= min.uses {
-			return
-		}
-		c.heap[pos] = min
-		c.res[min.id] = pos
-		c.heap[mc] = cur
-		c.res[cur.id] = mc
-		pos = mc
+// released notifies v that it has been removed from a cache, if v supports it.
+func released(v cache.Value) {
+	if e, ok := v.(cache.Evicter); ok {
+		e.Evicted()
 	}
 }
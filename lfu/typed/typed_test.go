@@ -0,0 +1,201 @@
+package typed
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestCache(t *testing.T) {
+	var victims []string
+	c := New[string, []byte](8,
+		SizeOf[string](func(b []byte) int { return len(b) }),
+		OnEvict(func(key string, b []byte) {
+			victims = append(victims, key+"="+string(b))
+		}),
+	)
+	check := func(want ...string) {
+		t.Helper()
+		if !reflect.DeepEqual(victims, want) {
+			t.Errorf("Victims: got %q, want %q", victims, want)
+		}
+		victims = nil
+	}
+	get := func(key string, n int) {
+		t.Helper()
+		for i := 0; i < n; i++ {
+			if _, ok := c.Get(key); !ok {
+				t.Fatalf("Get(%q): got miss, want hit", key)
+			}
+		}
+	}
+
+	c.Put("a", []byte("123"))
+	c.Put("b", []byte("45"))
+	c.Put("c", []byte("6"))
+	get("a", 3)
+	get("c", 1)
+	check()
+
+	// Adding d must evict b, which is now least-frequently used.
+	c.Put("d", []byte("789"))
+	check("b=45")
+	if v, ok := c.Get("b"); ok {
+		t.Errorf("Get(b): got %q, want miss", v)
+	}
+	if n, s := c.Len(), c.Size(); n != 3 || s != 7 {
+		t.Errorf("Len, Size: got %d, %d; want 3, 7", n, s)
+	}
+
+	// Replacing a value reports the old one as evicted, but keeps its uses.
+	c.Put("c", []byte("xy"))
+	check("c=6")
+	if v, ok := c.Get("c"); !ok || string(v) != "xy" {
+		t.Errorf("Get(c): got %q, %v; want xy, true", v, ok)
+	}
+
+	// A value larger than the capacity is not stored.
+	c.Put("big", []byte("123456789"))
+	check()
+	if _, ok := c.Get("big"); ok {
+		t.Error("Get(big): got hit, want miss")
+	}
+
+	if v, ok := c.Drop("a"); !ok || string(v) != "123" {
+		t.Errorf("Drop(a): got %q, %v; want 123, true", v, ok)
+	}
+	if _, ok := c.Drop("a"); ok {
+		t.Error("Drop(a): got true, want false")
+	}
+	check("a=123")
+
+	c.Reset()
+	check("d=789", "c=xy")
+	if n := c.Size(); n != 0 {
+		t.Errorf("Size after Reset: got %d, want 0", n)
+	}
+}
+
+func TestHeapOrder(t *testing.T) {
+	const n = 50
+	c := New[int, string](n)
+	for i := 0; i < n; i++ {
+		c.Put(i, fmt.Sprint(i))
+		for j := 0; j < (i*7)%n; j++ {
+			c.Get(i)
+		}
+	}
+	var order []int
+	c.onEvict = func(key int, _ string) { order = append(order, (key*7)%n) }
+	c.Reset()
+	for i := 1; i < len(order); i++ {
+		if order[i-1] > order[i] {
+			t.Fatalf("Eviction order is not by use count: %v", order)
+		}
+	}
+	if len(order) != n {
+		t.Errorf("Evicted %d entries, want %d", len(order), n)
+	}
+}
+
+func TestNil(t *testing.T) {
+	var c *Cache[string, int]
+	c.Put("x", 1) // shouldn't crash
+	if v, ok := c.Get("x"); ok {
+		t.Errorf("Get(x): got %v, want miss", v)
+	}
+	if _, ok := c.Drop("x"); ok {
+		t.Error("Drop(x): got true, want false")
+	}
+	if n := c.Len(); n != 0 {
+		t.Errorf("Len: got %d, want 0", n)
+	}
+	if n := c.Cap(); n != 0 {
+		t.Errorf("Cap: got %d, want 0", n)
+	}
+	c.Reset()
+}
+
+type sized struct {
+	name string
+	size int
+	log  *[]string
+}
+
+func (s sized) Size() int { return s.size }
+func (s sized) Admitted() { *s.log = append(*s.log, "+"+s.name) }
+func (s sized) Evicted()  { *s.log = append(*s.log, "-"+s.name) }
+
+func TestValueMethods(t *testing.T) {
+	// Without options, the methods of a value are not used: each value has
+	// size 1, and no value is notified of its lifecycle.
+	var log []string
+	c := New[int, sized](5)
+	c.Put(1, sized{"a", 2, &log})
+	c.Put(2, sized{"b", -3, &log}) // the negative size is not seen
+	if n := c.Size(); n != 2 {
+		t.Errorf("Size: got %d, want 2", n)
+	}
+	c.Reset()
+	if len(log) != 0 {
+		t.Errorf("Lifecycle: got %q, want none", log)
+	}
+}
+
+func TestLifecycleOptions(t *testing.T) {
+	var log []string
+	c := New[int, sized](5,
+		SizeOf[int](sized.Size),
+		OnAdmit[int](sized.Admitted),
+		OnRelease[int](sized.Evicted),
+	)
+	c.Put(1, sized{"a", 2, &log})
+	c.Put(2, sized{"b", 3, &log})
+	c.Get(2)
+	c.Put(3, sized{"c", 1, &log}) // evicts a
+	if n := c.Size(); n != 4 {
+		t.Errorf("Size: got %d, want 4", n)
+	}
+
+	// Replacing a value with itself does not release it.
+	c.Put(3, sized{"c", 1, &log})
+	c.Reset()
+	if want := []string{"+a", "+b", "-a", "+c", "-c", "-b"}; !reflect.DeepEqual(log, want) {
+		t.Errorf("Lifecycle: got %q, want %q", log, want)
+	}
+}
+
+func TestFrequencies(t *testing.T) {
+	c := New[string, int](10, DynamicAging[string, int]())
+	for i, key := range []string{"a", "b", "c"} {
+		c.Put(key, i)
+	}
+	c.Get("b")
+	c.Get("b")
+	c.Get("c")
+	c.SetCap(2) // evicts a, advancing the age to 1
+	c.Get("c")
+
+	want := []Info[string]{
+		{ID: "b", Size: 1, Uses: 3, Weight: 3},
+		{ID: "c", Size: 1, Uses: 3, Weight: 4},
+	}
+	if got := c.Frequencies(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Frequencies: got %+v, want %+v", got, want)
+	}
+}
+
+func TestCompact(t *testing.T) {
+	c := New[int, string](1000)
+	for i := 0; i < 1000; i++ {
+		c.Put(i, "x")
+	}
+	c.SetCap(3)
+	c.Compact()
+	if n := cap(c.heap); n != 3 {
+		t.Errorf("Heap capacity: got %d, want 3", n)
+	}
+	if n := c.Len(); n != 3 {
+		t.Errorf("Len: got %d, want 3", n)
+	}
+}
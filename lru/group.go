@@ -0,0 +1,102 @@
+package lru
+
+import (
+	"sync"
+
+	"github.com/creachadair/cache"
+)
+
+// Group manages a bounded collection of sub-caches indexed by a parent key,
+// such as per-tenant caches.  Sub-caches are created on demand and, when the
+// group is full, the least-recently used sub-cache is removed as a whole.  A
+// removed sub-cache is Reset, so the lifecycle hooks of its values run as for
+// any other eviction.  A *Group is safe for concurrent access by multiple
+// goroutines.  A nil *Group behaves as a group with 0 capacity.
+type Group struct {
+	μ       sync.Mutex
+	subs    *Cache                  // resident sub-caches, as subCache values
+	newFunc func(key string) *Cache // constructs a new sub-cache
+}
+
+// NewGroup returns a new empty group with capacity for the specified number
+// of sub-caches.  The newCache function is called to construct the sub-cache
+// for a key the first time it is requested; it must not return nil.
+func NewGroup(capacity int, newCache func(key string) *Cache) *Group {
+	return &Group{
+		subs:    New(capacity, Unlocked(), OnEvict(resetSubCache)),
+		newFunc: newCache,
+	}
+}
+
+// Cache returns the sub-cache for key, creating it if necessary.  The
+// returned cache becomes the most-recently used member of g, and creating a
+// new one may remove the least-recently used sub-cache.  If g has 0 capacity,
+// Cache returns nil, which behaves as an empty cache.
+//
+// A caller may continue to use a sub-cache after it has been removed from
+// the group, but the group will no longer manage it.
+func (g *Group) Cache(key string) *Cache {
+	if g == nil {
+		return nil
+	}
+	g.μ.Lock()
+	defer g.μ.Unlock()
+	if v := g.subs.Get(key); v != nil {
+		return v.(subCache).Cache
+	} else if g.subs.Cap() <= 0 {
+		return nil
+	}
+	c := g.newFunc(key)
+	g.subs.Put(key, subCache{c})
+	return c
+}
+
+// Drop removes the sub-cache for key from g, resetting it, and reports
+// whether such a sub-cache was present.
+func (g *Group) Drop(key string) bool {
+	if g == nil {
+		return false
+	}
+	g.μ.Lock()
+	defer g.μ.Unlock()
+	if g.subs.Get(key) == nil {
+		return false
+	}
+	g.subs.Drop(key)
+	return true
+}
+
+// Len returns the number of sub-caches currently in g.
+func (g *Group) Len() int {
+	if g == nil {
+		return 0
+	}
+	g.μ.Lock()
+	defer g.μ.Unlock()
+	return g.subs.Size()
+}
+
+// Cap returns the maximum number of sub-caches in g.
+func (g *Group) Cap() int {
+	if g == nil {
+		return 0
+	}
+	return g.subs.Cap()
+}
+
+// Reset removes and resets all the sub-caches in g, leaving it empty.  This
+// operation does not change the capacity of g.
+func (g *Group) Reset() {
+	if g != nil {
+		g.μ.Lock()
+		defer g.μ.Unlock()
+		g.subs.Reset()
+	}
+}
+
+// subCache adapts a *Cache to a cache.Value of size 1.
+type subCache struct{ *Cache }
+
+func (subCache) Size() int { return 1 }
+
+func resetSubCache(v cache.Value) { v.(subCache).Reset() }
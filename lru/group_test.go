@@ -0,0 +1,77 @@
+package lru
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestGroup(t *testing.T) {
+	var log []string
+	g := NewGroup(2, func(key string) *Cache {
+		log = append(log, "new "+key)
+		return New(10)
+	})
+	put := func(key, id string) {
+		g.Cache(key).Put(id, lvalue{name: key + "/" + id, log: &log})
+	}
+	check := func(want ...string) {
+		t.Helper()
+		if got := strings.Join(log, ","); got != strings.Join(want, ",") {
+			t.Errorf("Log: got %q, want %q", got, strings.Join(want, ","))
+		}
+		log = nil
+	}
+
+	put("a", "1")
+	put("a", "2")
+	put("b", "1")
+	check("new a", "+a/1", "+a/2", "new b", "+b/1")
+
+	// Creating c should remove a, which is least recently used.
+	put("c", "1")
+	check("new c", "-a/1", "-a/2", "+c/1")
+	if n := g.Len(); n != 2 {
+		t.Errorf("Len: got %d, want 2", n)
+	}
+
+	// Touching b makes c the next to go.
+	put("b", "2")
+	put("a", "3")
+	check("+b/2", "new a", "-c/1", "+a/3")
+
+	if !g.Drop("b") {
+		t.Error("Drop(b): got false, want true")
+	}
+	if g.Drop("b") {
+		t.Error("Drop(b): got true, want false")
+	}
+	check("-b/1", "-b/2")
+
+	g.Reset()
+	check("-a/3")
+	if n := g.Len(); n != 0 {
+		t.Errorf("Len after Reset: got %d, want 0", n)
+	}
+}
+
+func TestGroupEmpties(t *testing.T) {
+	newCache := func(string) *Cache {
+		t.Fatal("Unexpected call to newCache")
+		return nil
+	}
+	for _, g := range []*Group{nil, NewGroup(0, newCache)} {
+		if c := g.Cache("x"); c != nil {
+			t.Errorf("Cache(x): got %v, want nil", c)
+		}
+		if g.Drop("x") {
+			t.Error("Drop(x): got true, want false")
+		}
+		if n := g.Len(); n != 0 {
+			t.Errorf("Len: got %d, want 0", n)
+		}
+		if n := g.Cap(); n != 0 {
+			t.Errorf("Cap: got %d, want 0", n)
+		}
+		g.Reset() // shouldn't crash
+	}
+}
@@ -2,7 +2,10 @@ package lru
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
+	"reflect"
+	"sort"
 	"strings"
 	"sync"
 	"testing"
@@ -46,13 +49,13 @@ func TestCapacity(t *testing.T) {
 		{"?", "m", "123456789", ""},           // hit
 		{"?", "x", "", ""},                    // miss
 		{"?", "e", "qqq", ""},                 // hit
-		{"-", "e", "", "qqq"},                 // drop hit
+		{"-", "e", "qqq", "qqq"},              // drop hit
 		{"-", "x", "", ""},                    // drop miss
 		{"?", "e", "", ""},                    // miss
 	}
 	for _, test := range tests {
 		victim = ""
-		t.Logf("before %s %q: %s", test.op, test.id, c.seq)
+		t.Logf("before %s %q: %+v", test.op, test.id, c.Order())
 		switch test.op {
 		case "+":
 			c.Put(test.id, evalue(test.value))
@@ -78,7 +81,7 @@ func TestCapacity(t *testing.T) {
 		if test.victim != "" && victim != test.victim {
 			t.Errorf("Victim after %s %q: got %q, want %q", test.op, test.id, victim, test.victim)
 		}
-		t.Logf(" after %s %q: %s; victim=%q", test.op, test.id, c.seq, victim)
+		t.Logf(" after %s %q: %+v; victim=%q", test.op, test.id, c.Order(), victim)
 	}
 }
 
@@ -102,8 +105,8 @@ func TestConcurrency(t *testing.T) {
 				case '*':
 					c.Reset()
 				}
-				if n := c.Size(); n < 0 || n > c.cap {
-					t.Errorf("Size %d out of range [0..%d]", n, c.cap)
+				if n := c.Size(); n < 0 || n > c.Cap() {
+					t.Errorf("Size %d out of range [0..%d]", n, c.Cap())
 				}
 			}
 		}()
@@ -144,26 +147,6 @@ func TestEmpties(t *testing.T) {
 	}
 }
 
-func (e *entry) String() string {
-	var buf bytes.Buffer
-	for cur := e.next; ; cur = cur.next {
-		v := cur.value
-		if v == nil {
-			v = evalue("")
-		}
-		fmt.Fprintf(&buf, "%q [%s] ", cur.id, string(v.(evalue)))
-		if cur.prev.next == cur {
-			fmt.Fprint(&buf, "✓ ")
-		} else {
-			fmt.Fprint(&buf, "✗ ")
-		}
-		if cur == e {
-			break
-		}
-	}
-	return buf.String()
-}
-
 func ExampleNew() {
 	c := New(200)
 	c.Put("x", cache.Nil)
@@ -175,3 +158,973 @@ func ExampleNew() {
 	}
 	// Output: x is present
 }
+
+func TestRename(t *testing.T) {
+	var victims []string
+	c := New(3, OnEvict(func(v cache.Value) {
+		victims = append(victims, string(v.(evalue)))
+	}))
+	c.Put("x", evalue("1"))
+	c.Put("y", evalue("2"))
+	c.Put("z", evalue("3"))
+
+	if c.Rename("q", "r") {
+		t.Error("Rename(q, r): got true, want false")
+	}
+	if !c.Rename("x", "w") {
+		t.Error("Rename(x, w): got false, want true")
+	}
+	if v := c.Get("x"); v != nil {
+		t.Errorf("Get(x): got %q, want nil", v)
+	}
+	if !c.Rename("w", "z") {
+		t.Error("Rename(w, z): got false, want true")
+	}
+	if got := strings.Join(victims, ","); got != "3" {
+		t.Errorf("Victims after rename: got %q, want %q", got, "3")
+	}
+	if v := c.Get("z"); v != evalue("1") {
+		t.Errorf("Get(z): got %q, want %q", v, "1")
+	}
+	if n := c.Size(); n != 2 {
+		t.Errorf("Size: got %d, want 2", n)
+	}
+
+	// Renaming should not change the eviction order: y is the oldest.
+	victims = nil
+	c.Put("a", evalue("4"))
+	c.Put("b", evalue("5"))
+	if got := strings.Join(victims, ","); got != "2" {
+		t.Errorf("Victims after Put: got %q, want %q", got, "2")
+	}
+}
+
+func TestLowWater(t *testing.T) {
+	var victims []string
+	c := New(5, LowWater(2), OnEvict(func(v cache.Value) {
+		victims = append(victims, string(v.(evalue)))
+	}))
+	for _, id := range []string{"a", "b", "c", "d", "e"} {
+		c.Put(id, evalue(id))
+	}
+	if len(victims) != 0 {
+		t.Errorf("Victims before reaching capacity: got %q, want none", victims)
+	}
+
+	// Exceeding the capacity should evict down to the low watermark.
+	c.Put("f", evalue("f"))
+	if got := strings.Join(victims, ","); got != "a,b,c,d" {
+		t.Errorf("Victims: got %q, want %q", got, "a,b,c,d")
+	}
+	if n := c.Size(); n != 2 {
+		t.Errorf("Size: got %d, want 2", n)
+	}
+	for _, id := range []string{"e", "f"} {
+		if v := c.Get(id); v != evalue(id) {
+			t.Errorf("Get(%q): got %v, want %q", id, v, id)
+		}
+	}
+}
+
+// mvalue is a cache value whose size can change while it is cached.
+type mvalue struct{ size int }
+
+func (m *mvalue) Size() int { return m.size }
+
+func TestResize(t *testing.T) {
+	var victims []int
+	c := New(10, OnEvict(func(v cache.Value) {
+		victims = append(victims, v.(*mvalue).size)
+	}))
+	a, b, d := &mvalue{2}, &mvalue{3}, &mvalue{4}
+	c.Put("a", a)
+	c.Put("b", b)
+	c.Put("d", d)
+
+	if c.Resize("nonesuch") {
+		t.Error("Resize(nonesuch): got true, want false")
+	}
+
+	// Shrinking a value should release space without evicting anything.
+	d.size = 1
+	if !c.Resize("d") {
+		t.Error("Resize(d): got false, want true")
+	}
+	if n := c.Size(); n != 6 {
+		t.Errorf("Size after shrink: got %d, want 6", n)
+	}
+
+	// Growing a value beyond capacity should evict something else.
+	d.size = 7
+	if !c.Resize("d") {
+		t.Error("Resize(d): got false, want true")
+	}
+	if n := c.Size(); n != 10 {
+		t.Errorf("Size after grow: got %d, want 10", n)
+	}
+	if len(victims) != 1 || victims[0] != 2 {
+		t.Errorf("Victims after grow: got %v, want [2]", victims)
+	}
+
+	// Growing a value past the total capacity should evict it.
+	victims = nil
+	d.size = 11
+	if c.Resize("d") {
+		t.Error("Resize(d): got true, want false")
+	}
+	if n := c.Size(); n != 3 {
+		t.Errorf("Size after overflow: got %d, want 3", n)
+	}
+	if len(victims) != 1 || victims[0] != 11 {
+		t.Errorf("Victims after overflow: got %v, want [11]", victims)
+	}
+}
+
+func TestCheckSize(t *testing.T) {
+	type change struct {
+		id        string
+		old, curr int
+	}
+	var changes []change
+	c := New(10, CheckSize(func(id string, recorded, current int) {
+		changes = append(changes, change{id, recorded, current})
+	}))
+	a, b := &mvalue{2}, &mvalue{3}
+	c.Put("a", a)
+	c.Put("b", b)
+
+	b.size = 4
+	if !c.Resize("b") {
+		t.Error("Resize(b): got false, want true")
+	}
+	a.size = 5
+	c.Reset()
+	if len(changes) != 1 || changes[0] != (change{"a", 2, 5}) {
+		t.Errorf("Size changes: got %+v, want [{a 2 5}]", changes)
+	}
+
+	// Without a callback, a size change should panic.
+	p := New(10, CheckSize(nil))
+	p.Put("a", a)
+	a.size = 6
+	defer func() {
+		if x := recover(); x == nil {
+			t.Error("Reset did not panic on a size change")
+		}
+	}()
+	p.Reset()
+}
+
+func TestCheckSizeRecover(t *testing.T) {
+	c := New(10, CheckSize(nil))
+	a := &mvalue{1}
+	c.Put("a", a)
+	c.Put("b", &mvalue{1})
+	a.size = 3
+
+	func() {
+		defer func() {
+			if x := recover(); x == nil {
+				t.Error("Drop did not panic on a size change")
+			}
+		}()
+		c.Drop("a")
+	}()
+
+	// The failed eviction must leave the cache as it was.
+	if n := c.Size(); n != 2 {
+		t.Errorf("Size: got %d, want 2", n)
+	}
+	if got, want := c.Order(), []Info{{"a", 1}, {"b", 1}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Order: got %+v, want %+v", got, want)
+	}
+	a.size = 1
+	c.Reset()
+	if n := c.Size(); n != 0 {
+		t.Errorf("Size after Reset: got %d, want 0", n)
+	}
+}
+
+func TestOrder(t *testing.T) {
+	c := New(10)
+	if got := c.Order(); len(got) != 0 {
+		t.Errorf("Order of empty cache: got %+v, want empty", got)
+	}
+	c.Put("a", cache.String("alpha"))
+	c.Put("b", cache.String("b"))
+	c.Put("c", cache.String("cc"))
+	c.Get("a")
+
+	want := []Info{{"b", 1}, {"c", 2}, {"a", 5}}
+	got := c.Order()
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Order: got %+v, want %+v", got, want)
+	}
+
+	// Order should not affect recency.
+	if again := c.Order(); !reflect.DeepEqual(again, want) {
+		t.Errorf("Order again: got %+v, want %+v", again, want)
+	}
+}
+
+func TestString(t *testing.T) {
+	var z *Cache
+	c := New(10)
+	c.Put("a", cache.String("alpha"))
+	c.Put("b", cache.String("b"))
+	c.Get("a")
+
+	for _, test := range []struct {
+		c    *Cache
+		want string
+	}{
+		{z, "lru.Cache(size=0, cap=0, len=0)"},
+		{c, "lru.Cache(size=6, cap=10, len=2)"},
+	} {
+		if got := test.c.String(); got != test.want {
+			t.Errorf("String: got %q, want %q", got, test.want)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := c.Dump(&buf); err != nil {
+		t.Fatalf("Dump failed: %v", err)
+	}
+	const want = `size 6/10, 2 entries
+  "b" size=1
+  "a" size=5
+`
+	if got := buf.String(); got != want {
+		t.Errorf("Dump: got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestPurge(t *testing.T) {
+	var victims []string
+	c := New(10, OnEvict(func(v cache.Value) {
+		victims = append(victims, string(v.(evalue)))
+	}))
+	keys := []string{"a", "b", "c", "d", "e", "f", "g", "h"}
+	for i, key := range keys {
+		c.Put(key, evalue(key))
+		for j := 0; j < i; j++ {
+			c.Get(key) // make later keys hotter (for LFU)
+		}
+	}
+
+	c.Purge(0)
+	if len(victims) != 0 {
+		t.Errorf("Purge(0): got victims %q, want none", victims)
+	}
+	c.Purge(0.5)
+	if got := strings.Join(victims, ","); got != "a,b,c,d" {
+		t.Errorf("Purge(0.5): got victims %q, want %q", got, "a,b,c,d")
+	}
+	if n := c.Size(); n != 4 {
+		t.Errorf("Size after Purge(0.5): got %d, want 4", n)
+	}
+
+	victims = nil
+	c.ResetQuiet()
+	if len(victims) != 0 {
+		t.Errorf("ResetQuiet: got victims %q, want none", victims)
+	}
+	if n := c.Size(); n != 0 {
+		t.Errorf("Size after ResetQuiet: got %d, want 0", n)
+	}
+	for _, key := range keys {
+		if v := c.Get(key); v != nil {
+			t.Errorf("Get(%q) after ResetQuiet: got %q, want nil", key, v)
+		}
+	}
+
+	// The cache should remain usable after a quiet reset.
+	c.Put("x", evalue("x"))
+	c.Purge(1)
+	if got := strings.Join(victims, ","); got != "x" {
+		t.Errorf("Purge(1): got victims %q, want %q", got, "x")
+	}
+}
+
+func TestEvictLargest(t *testing.T) {
+	var victims []string
+	c := New(10, EvictLargest(3), OnEvict(func(v cache.Value) {
+		victims = append(victims, string(v.(cache.String)))
+	}))
+	for _, s := range []string{"a", "bbb", "cc", "dddd"} {
+		c.Put(s, cache.String(s))
+	}
+
+	// Among the three least-recently used entries (a, bbb, cc), the largest
+	// is bbb, and evicting it alone makes room.
+	c.Put("ee", cache.String("ee"))
+	if got := strings.Join(victims, ","); got != "bbb" {
+		t.Errorf("Victims: got %q, want %q", got, "bbb")
+	}
+
+	// Ties go to the least-recently used entry.
+	victims = nil
+	c.Put("f", cache.String("f"))
+	c.Get("dddd")
+	c.Put("gg", cache.String("gg")) // candidates: a, cc, ee
+	if got := strings.Join(victims, ","); got != "cc" {
+		t.Errorf("Victims: got %q, want %q", got, "cc")
+	}
+}
+
+func TestKeysPage(t *testing.T) {
+	c := New(100)
+	var want []string
+	for _, key := range []string{"", "b", "a", "d", "c", "e", "aa", "g", "f"} {
+		c.Put(key, evalue(key))
+		want = append(want, key)
+	}
+	sort.Strings(want)
+
+	for _, limit := range []int{0, 1, 2, 4, 9, 20} {
+		var got []string
+		var cursor string
+		for pages := 0; ; pages++ {
+			keys, next := c.KeysPage(cursor, limit)
+			if limit > 0 && len(keys) > limit {
+				t.Errorf("KeysPage(%q, %d): got %d keys, want ≤ %d", cursor, limit, len(keys), limit)
+			}
+			got = append(got, keys...)
+			if next == "" {
+				break
+			} else if pages > len(want) {
+				t.Fatalf("KeysPage(_, %d): too many pages", limit)
+			}
+			cursor = next
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("KeysPage(_, %d): got %q, want %q", limit, got, want)
+		}
+	}
+}
+
+func TestUnlocked(t *testing.T) {
+	c := New(2, Unlocked())
+	c.Put("a", evalue("a"))
+	c.Put("b", evalue("b"))
+	c.Get("b")
+	c.Put("c", evalue("c"))
+	if v := c.Get("a"); v != nil {
+		t.Errorf("Get(a): got %q, want nil", v)
+	}
+	if n := c.Size(); n != 2 {
+		t.Errorf("Size: got %d, want 2", n)
+	}
+	c.Reset()
+	if n := c.Size(); n != 0 {
+		t.Errorf("Size after Reset: got %d, want 0", n)
+	}
+}
+
+func BenchmarkGet(b *testing.B) {
+	for _, test := range []struct {
+		name string
+		opts []Option
+	}{
+		{"Locked", nil},
+		{"Unlocked", []Option{Unlocked()}},
+	} {
+		b.Run(test.name, func(b *testing.B) {
+			c := New(100, test.opts...)
+			c.Put("key", evalue("value"))
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				c.Get("key")
+			}
+		})
+	}
+}
+
+// lvalue is a cache value that records its lifecycle notifications.
+type lvalue struct {
+	name string
+	log  *[]string
+}
+
+func (lvalue) Size() int   { return 1 }
+func (v lvalue) Admitted() { *v.log = append(*v.log, "+"+v.name) }
+func (v lvalue) Evicted()  { *v.log = append(*v.log, "-"+v.name) }
+
+func TestLifecycle(t *testing.T) {
+	var log []string
+	val := func(name string) lvalue { return lvalue{name, &log} }
+
+	c := New(2)
+	c.Put("a", val("a1"))
+	c.Put("a", val("a2")) // replacement
+	c.Put("b", val("b"))
+	c.Put("c", val("c")) // evicts a
+	c.Reset()
+	c.Put("d", val("d"))
+	c.ResetQuiet()
+
+	want := []string{"+a1", "-a1", "+a2", "+b", "-a2", "+c", "-b", "-c", "+d", "-d"}
+	if !reflect.DeepEqual(log, want) {
+		t.Errorf("Lifecycle: got %q, want %q", log, want)
+	}
+}
+
+// cvalue is a cache value that records when it is closed.
+type cvalue struct {
+	name string
+	log  *[]string
+}
+
+func (cvalue) Size() int { return 1 }
+
+func (v cvalue) Close() error {
+	*v.log = append(*v.log, v.name)
+	if v.name == "bad" {
+		return errors.New("close failed")
+	}
+	return nil
+}
+
+func TestCloseOnEvict(t *testing.T) {
+	var closed, failed []string
+	val := func(name string) cvalue { return cvalue{name, &closed} }
+
+	c := New(2, CloseOnEvict(func(v cache.Value, err error) {
+		failed = append(failed, v.(cvalue).name+": "+err.Error())
+	}))
+	c.Put("a", val("a1"))
+	c.Put("a", val("a2")) // replacement closes a1
+	c.Put("b", val("bad"))
+	c.Put("c", val("c"))    // evicts a2
+	c.Put("d", val("d"))    // evicts bad
+	c.Put("x", evalue("x")) // evicts c; not a closer
+	c.ResetQuiet()          // closes d
+
+	if want := []string{"a1", "a2", "bad", "c", "d"}; !reflect.DeepEqual(closed, want) {
+		t.Errorf("Closed: got %q, want %q", closed, want)
+	}
+	if want := []string{"bad: close failed"}; !reflect.DeepEqual(failed, want) {
+		t.Errorf("Failed: got %q, want %q", failed, want)
+	}
+}
+
+func TestZeroValue(t *testing.T) {
+	var c Cache
+	c.Put("a", evalue("a"))
+	if v := c.Get("a"); v != nil {
+		t.Errorf("Get(a) at zero capacity: got %q, want nil", v)
+	}
+	c.Put("bad", &mvalue{-1})      // no capacity, so this does not panic
+	New(0).Put("bad", &mvalue{-1}) // likewise
+
+	c.SetCap(2)
+	if n := c.Cap(); n != 2 {
+		t.Errorf("Cap: got %d, want 2", n)
+	}
+	c.Put("a", evalue("a"))
+	c.Put("b", evalue("b"))
+	c.Get("b")
+	c.Put("c", evalue("c"))
+	if v := c.Get("a"); v != nil {
+		t.Errorf("Get(a): got %q, want nil", v)
+	}
+	for _, id := range []string{"b", "c"} {
+		if v := c.Get(id); v != evalue(id) {
+			t.Errorf("Get(%q): got %v, want %q", id, v, id)
+		}
+	}
+}
+
+func TestZeroValueConcurrent(t *testing.T) {
+	// Order must not observe the lazy initialization done by Put without
+	// holding the lock; run under -race to check.
+	var c Cache
+	c.SetCap(10)
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		c.Put("a", evalue("a"))
+	}()
+	go func() {
+		defer wg.Done()
+		c.Order()
+	}()
+	wg.Wait()
+	if v := c.Get("a"); v != evalue("a") {
+		t.Errorf("Get(a): got %v, want %q", v, "a")
+	}
+}
+
+func TestSetCap(t *testing.T) {
+	var victims []string
+	c := New(4, OnEvict(func(v cache.Value) {
+		victims = append(victims, string(v.(evalue)))
+	}))
+	for i, id := range []string{"a", "b", "c", "d"} {
+		c.Put(id, evalue(id))
+		for j := 0; j < i; j++ {
+			c.Get(id)
+		}
+	}
+
+	c.SetCap(2)
+	if got := strings.Join(victims, ","); got != "a,b" {
+		t.Errorf("Victims after SetCap(2): got %q, want %q", got, "a,b")
+	}
+	if n := c.Size(); n != 2 {
+		t.Errorf("Size after SetCap(2): got %d, want 2", n)
+	}
+
+	victims = nil
+	c.SetCap(0)
+	if got := strings.Join(victims, ","); got != "c,d" {
+		t.Errorf("Victims after SetCap(0): got %q, want %q", got, "c,d")
+	}
+	c.Put("e", evalue("e"))
+	if n := c.Size(); n != 0 {
+		t.Errorf("Size after SetCap(0): got %d, want 0", n)
+	}
+}
+
+func TestName(t *testing.T) {
+	c := New(10, Name("test"))
+	if got := c.Name(); got != "test" {
+		t.Errorf("Name: got %q, want %q", got, "test")
+	}
+	if got, want := c.String(), `lru.Cache(name="test", size=0, cap=10, len=0)`; got != want {
+		t.Errorf("String: got %q, want %q", got, want)
+	}
+
+	defer func() {
+		const want = `lru cache "test": negative value size for "bad"`
+		if x := recover(); x != want {
+			t.Errorf("Put panic: got %v, want %q", x, want)
+		}
+	}()
+	c.Put("bad", &mvalue{-1})
+}
+
+func TestGetAllocs(t *testing.T) {
+	c := New(10, OnEvict(func(cache.Value) {}), CheckSize(nil), Name("allocs"))
+	c.Put("key", evalue("value"))
+	c.Put("other", evalue("value"))
+
+	// Alternate keys so that every Get updates the policy state.
+	keys := []string{"key", "other"}
+	var i int
+	if n := testing.AllocsPerRun(1000, func() {
+		if c.Get(keys[i%2]) == nil {
+			t.Fatal("Get missed")
+		}
+		i++
+	}); n != 0 {
+		t.Errorf("Get hit: got %v allocations, want 0", n)
+	}
+	if n := testing.AllocsPerRun(1000, func() { c.Get("nonesuch") }); n != 0 {
+		t.Errorf("Get miss: got %v allocations, want 0", n)
+	}
+}
+
+// fvalue is a cache value used by the fuzz tests.
+type fvalue struct {
+	id   string
+	size int
+}
+
+func (f fvalue) Size() int { return f.size }
+
+// model is a simple reference implementation of an LRU cache, used to check
+// the behaviour of Cache.
+type model struct {
+	cap     int
+	size    int
+	order   []string // least-recently used first
+	vals    map[string]fvalue
+	victims []fvalue
+}
+
+func (m *model) index(id string) int {
+	for i, key := range m.order {
+		if key == id {
+			return i
+		}
+	}
+	return -1
+}
+
+func (m *model) remove(id string) {
+	i := m.index(id)
+	m.order = append(m.order[:i], m.order[i+1:]...)
+	m.size -= m.vals[id].size
+	m.victims = append(m.victims, m.vals[id])
+	delete(m.vals, id)
+}
+
+func (m *model) put(id string, v fvalue) {
+	if v.size > m.cap {
+		return
+	} else if _, ok := m.vals[id]; ok {
+		m.remove(id)
+	}
+	for len(m.order) != 0 && m.size+v.size > m.cap {
+		m.remove(m.order[0])
+	}
+	m.order = append(m.order, id)
+	m.vals[id] = v
+	m.size += v.size
+}
+
+func (m *model) get(id string) cache.Value {
+	v, ok := m.vals[id]
+	if !ok {
+		return nil
+	}
+	i := m.index(id)
+	m.order = append(append(m.order[:i], m.order[i+1:]...), id)
+	return v
+}
+
+func (m *model) rename(oldID, newID string) bool {
+	v, ok := m.vals[oldID]
+	if !ok {
+		return false
+	} else if oldID != newID {
+		if _, ok := m.vals[newID]; ok {
+			m.remove(newID)
+		}
+		m.order[m.index(oldID)] = newID
+		delete(m.vals, oldID)
+		m.vals[newID] = v
+	}
+	return true
+}
+
+func FuzzModel(f *testing.F) {
+	f.Add([]byte("\x00\x03\x10\x02\x20\x04\x01\x00\x30\x05\x11\x00\x02\x12"))
+	f.Add([]byte("\x00\x09\x10\x09\x20\x01\x02\x30\x01\x00\x31\x00\x00"))
+	f.Fuzz(func(t *testing.T, ops []byte) {
+		const capacity = 10
+		var victims []fvalue
+		c := New(capacity, OnEvict(func(v cache.Value) {
+			victims = append(victims, v.(fvalue))
+		}))
+		m := &model{cap: capacity, vals: make(map[string]fvalue)}
+
+		for i := 0; i+1 < len(ops); i += 2 {
+			id := string('a' + rune(ops[i]>>4)%8)
+			arg := ops[i+1]
+			switch ops[i] % 3 {
+			case 0:
+				v := fvalue{id: fmt.Sprint(id, i), size: int(arg % (capacity + 2))}
+				c.Put(id, v)
+				m.put(id, v)
+			case 1:
+				if got, want := c.Get(id), m.get(id); got != want {
+					t.Fatalf("Get(%q): got %v, want %v", id, got, want)
+				}
+			case 2:
+				newID := string('a' + rune(arg)%8)
+				if got, want := c.Rename(id, newID), m.rename(id, newID); got != want {
+					t.Fatalf("Rename(%q, %q): got %v, want %v", id, newID, got, want)
+				}
+			}
+
+			if !reflect.DeepEqual(victims, m.victims) {
+				t.Fatalf("Op %d: victims: got %v, want %v", i/2, victims, m.victims)
+			}
+			if got := c.Size(); got != m.size {
+				t.Fatalf("Op %d: size: got %d, want %d", i/2, got, m.size)
+			}
+			var order []string
+			for _, e := range c.Order() {
+				order = append(order, e.ID)
+			}
+			if !reflect.DeepEqual(order, m.order) && len(order)+len(m.order) != 0 {
+				t.Fatalf("Op %d: order: got %q, want %q", i/2, order, m.order)
+			}
+		}
+	})
+}
+
+func TestOnNegativeSize(t *testing.T) {
+	var rejected []string
+	c := New(10, OnNegativeSize(func(id string, v cache.Value) {
+		rejected = append(rejected, id)
+	}))
+	c.Put("bad", &mvalue{-1})
+	if v := c.Get("bad"); v != nil {
+		t.Errorf("Get(bad): got %v, want nil", v)
+	}
+
+	v := &mvalue{3}
+	c.Put("ok", v)
+	v.size = -2
+	if c.Resize("ok") {
+		t.Error("Resize(ok): got true, want false")
+	}
+	if n := c.Size(); n != 0 {
+		t.Errorf("Size: got %d, want 0", n)
+	}
+	if want := []string{"bad", "ok"}; !reflect.DeepEqual(rejected, want) {
+		t.Errorf("Rejected: got %q, want %q", rejected, want)
+	}
+}
+
+func TestClampAudit(t *testing.T) {
+	c := New(10, ClampSize(4))
+	a, b := &mvalue{2}, &mvalue{100}
+	c.Put("a", a)
+	c.Put("b", b) // clamped to 4
+	if n := c.Size(); n != 6 {
+		t.Errorf("Size: got %d, want 6", n)
+	}
+	if d := c.Audit(); d != 0 {
+		t.Errorf("Audit: got %d, want 0", d)
+	}
+
+	a.size = 3
+	b.size = 1
+	if d := c.Audit(); d != -2 {
+		t.Errorf("Audit after change: got %d, want -2", d)
+	}
+	c.Resize("a")
+	c.Resize("b")
+	if d := c.Audit(); d != 0 {
+		t.Errorf("Audit after Resize: got %d, want 0", d)
+	}
+	if n := c.Size(); n != 4 {
+		t.Errorf("Size after Resize: got %d, want 4", n)
+	}
+}
+
+func TestResetOrder(t *testing.T) {
+	var victims []string
+	c := New(10, OnEvict(func(v cache.Value) {
+		victims = append(victims, string(v.(cache.String)))
+	}))
+	c.Put("a", cache.String("a"))
+	c.Put("b", cache.String("")) // zero-sized values are evicted too
+	c.Put("c", cache.String("c"))
+	c.Get("a")
+	c.Get("a")
+	c.Get("c")
+
+	c.Reset()
+	if got, want := strings.Join(victims, ","), ",a,c"; got != want {
+		t.Errorf("Reset victims: got %q, want %q", got, want)
+	}
+	if got := c.Order(); len(got) != 0 {
+		t.Errorf("Entries after Reset: got %+v, want none", got)
+	}
+}
+
+func TestKeepRecency(t *testing.T) {
+	for _, keep := range []bool{false, true} {
+		var opts []Option
+		want := "b,c,a" // replacing a makes it most recent
+		if keep {
+			opts = append(opts, KeepRecency())
+			want = "a,b,c" // replacing a leaves it least recent
+		}
+		var victims []string
+		c := New(3, append(opts, OnEvict(func(v cache.Value) {
+			victims = append(victims, string(v.(evalue)))
+		}))...)
+		c.Put("a", evalue("a1"))
+		c.Put("b", evalue("b"))
+		c.Put("c", evalue("c"))
+		c.Put("a", evalue("a2"))
+		if got := strings.Join(victims, ","); got != "a1" {
+			t.Errorf("keep=%v: replacement victims: got %q, want %q", keep, got, "a1")
+		}
+
+		var order []string
+		for _, e := range c.Order() {
+			order = append(order, e.ID)
+		}
+		if got := strings.Join(order, ","); got != want {
+			t.Errorf("keep=%v: Order: got %q, want %q", keep, got, want)
+		}
+	}
+}
+
+func TestAdmit(t *testing.T) {
+	var asked []string
+	c := New(10, Admit(func(id string, v cache.Value) bool {
+		asked = append(asked, id)
+		return id != "no"
+	}))
+	c.Put("yes", evalue("1"))
+	c.Put("no", evalue("2"))
+	c.Put("yes", evalue("3")) // replacement is not consulted
+
+	if v := c.Get("no"); v != nil {
+		t.Errorf("Get(no): got %q, want nil", v)
+	}
+	if v := c.Get("yes"); v != evalue("3") {
+		t.Errorf("Get(yes): got %v, want %q", v, "3")
+	}
+	if want := []string{"yes", "no"}; !reflect.DeepEqual(asked, want) {
+		t.Errorf("Admit calls: got %q, want %q", asked, want)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	stale := map[string]bool{"b": true}
+	var evicted []string
+	c := New(10, Validate(func(id string, v cache.Value) bool {
+		return !stale[id]
+	}), OnEvict(func(v cache.Value) {
+		evicted = append(evicted, string(v.(evalue)))
+	}))
+	for _, id := range []string{"a", "b", "c"} {
+		c.Put(id, evalue(id))
+	}
+
+	if v := c.Get("a"); v != evalue("a") {
+		t.Errorf("Get(a): got %v, want %q", v, "a")
+	}
+	if v := c.Get("b"); v != nil {
+		t.Errorf("Get(b): got %v, want nil", v)
+	}
+	if n := c.Size(); n != 2 {
+		t.Errorf("Size: got %d, want 2", n)
+	}
+
+	// Once c becomes stale, the next Get evicts it.
+	stale["c"] = true
+	if v := c.Get("c"); v != nil {
+		t.Errorf("Get(c): got %v, want nil", v)
+	}
+	if want := []string{"b", "c"}; !reflect.DeepEqual(evicted, want) {
+		t.Errorf("Evicted: got %q, want %q", evicted, want)
+	}
+	if v := c.Get("a"); v != evalue("a") {
+		t.Errorf("Get(a): got %v, want %q", v, "a")
+	}
+}
+
+func TestResetBatched(t *testing.T) {
+	var victims []string
+	c := New(10, OnEvict(func(v cache.Value) {
+		victims = append(victims, string(v.(evalue)))
+	}))
+	for _, id := range []string{"a", "b", "c", "d", "e"} {
+		c.Put(id, evalue(id))
+	}
+
+	type step struct{ evicted, remaining int }
+	var steps []step
+	stop := func(evicted, remaining int) bool {
+		steps = append(steps, step{evicted, remaining})
+		return len(steps) < 1
+	}
+	if c.ResetBatched(2, stop) {
+		t.Error("ResetBatched: reported empty after stopping early")
+	}
+	if got := strings.Join(victims, ","); got != "a,b" {
+		t.Errorf("Victims after stopping: got %q, want %q", got, "a,b")
+	}
+
+	// Resume the reset to completion.
+	steps = nil
+	if !c.ResetBatched(2, func(evicted, remaining int) bool {
+		steps = append(steps, step{evicted, remaining})
+		return true
+	}) {
+		t.Error("ResetBatched: did not report empty")
+	}
+	if want := []step{{2, 1}}; !reflect.DeepEqual(steps, want) {
+		t.Errorf("Progress: got %v, want %v", steps, want)
+	}
+	if got := strings.Join(victims, ","); got != "a,b,c,d,e" {
+		t.Errorf("Victims: got %q, want %q", got, "a,b,c,d,e")
+	}
+	if n := c.Size(); n != 0 {
+		t.Errorf("Size: got %d, want 0", n)
+	}
+}
+
+func TestCompact(t *testing.T) {
+	c := New(1000)
+	for i := 0; i < 1000; i++ {
+		c.Put(fmt.Sprint(i), evalue("x"))
+	}
+	c.SetCap(3)
+	c.Compact()
+
+	// The cache should still behave normally after compaction.
+	before := c.Order()
+	c.Put("new", evalue("y"))
+	if v := c.Get("new"); v != evalue("y") {
+		t.Errorf("Get(new): got %v, want %q", v, "y")
+	}
+	if n := c.Size(); n != 3 {
+		t.Errorf("Size: got %d, want 3", n)
+	}
+	if v := c.Get(before[0].ID); v != nil {
+		t.Errorf("Get(%q): got %v, want nil", before[0].ID, v)
+	}
+
+	var z Cache
+	z.Compact() // shouldn't crash
+}
+
+// svalue is a closeable value with a slice field, so it is not comparable
+// with ==.
+type svalue struct {
+	cvalue
+	data []byte
+}
+
+func TestPutSameValue(t *testing.T) {
+	for _, opts := range [][]Option{nil, {KeepRecency()}} {
+		var closed []string
+		c := New(10, append(opts, CloseOnEvict(nil))...)
+		v := cvalue{"a", &closed}
+		c.Put("a", v)
+		c.Put("a", v) // same value: must not be closed
+		if len(closed) != 0 {
+			t.Errorf("Closed after re-Put: got %q, want none", closed)
+		}
+		if got := c.Get("a"); got != v {
+			t.Errorf("Get(a): got %v, want %v", got, v)
+		}
+
+		// Re-putting an uncomparable value must not panic.
+		b := cache.Bytes("xyz")
+		c.Put("b", b)
+		c.Put("b", b)
+		c.Put("b", cache.Bytes("xyz")) // a different slice
+		if got := c.Get("b"); got == nil {
+			t.Error("Get(b): got nil, want value")
+		}
+
+		// Likewise a struct holding a slice in an interface field.
+		e := cache.Entry{Value: []byte("x")}
+		c.Put("e", e)
+		c.Put("e", e)
+		c.Put("e", cache.Entry{Value: []byte("y")})
+
+		// A struct with a slice field is the same value if it holds the same
+		// slice, so it is not closed when re-put.
+		s1 := svalue{cvalue{"s1", &closed}, []byte("abc")}
+		c.Put("s", s1)
+		c.Put("s", s1)
+		if len(closed) != 0 {
+			t.Errorf("Closed after re-Put: got %q, want none", closed)
+		}
+		c.Put("s", svalue{cvalue{"s2", &closed}, []byte("abc")})
+		if want := []string{"s1"}; !reflect.DeepEqual(closed, want) {
+			t.Errorf("Closed after replacement: got %q, want %q", closed, want)
+		}
+
+		c.Reset()
+		sort.Strings(closed)
+		if want := []string{"a", "s1", "s2"}; !reflect.DeepEqual(closed, want) {
+			t.Errorf("Closed after Reset: got %q, want %q", closed, want)
+		}
+	}
+}
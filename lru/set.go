@@ -0,0 +1,92 @@
+package lru
+
+import (
+	"sync"
+
+	"github.com/creachadair/cache/lru/typed"
+)
+
+// Set implements a bounded set of string keys with a least-recently-used
+// replacement policy.  A Set is useful for deduplication windows and for
+// tracking recently-seen keys, where there are no values to store.  A *Set is
+// safe for concurrent access by multiple goroutines.  A nil *Set behaves as a
+// set with 0 capacity.
+type Set struct {
+	μ    sync.Mutex
+	keys *typed.Cache[string, struct{}] // unlocked; guarded by μ
+}
+
+// NewSet returns a new empty set with capacity for the specified number of
+// keys.
+func NewSet(capacity int) *Set {
+	return &Set{keys: typed.New(capacity, typed.Unlocked[string, struct{}]())}
+}
+
+// Add adds id to the set as its most-recently used key, evicting the least
+// recently used key if the set is full.  It reports whether id was already
+// present in the set.
+func (s *Set) Add(id string) bool {
+	if s == nil {
+		return false
+	}
+	s.μ.Lock()
+	defer s.μ.Unlock()
+	if _, ok := s.keys.Get(id); ok {
+		return true
+	}
+	s.keys.Put(id, struct{}{})
+	return false
+}
+
+// Contains reports whether id is present in the set.  If so, id becomes the
+// most-recently used key.
+func (s *Set) Contains(id string) bool {
+	if s == nil {
+		return false
+	}
+	s.μ.Lock()
+	defer s.μ.Unlock()
+	_, ok := s.keys.Get(id)
+	return ok
+}
+
+// Drop removes id from the set, and reports whether it was present.
+func (s *Set) Drop(id string) bool {
+	if s == nil {
+		return false
+	}
+	s.μ.Lock()
+	defer s.μ.Unlock()
+	_, ok := s.keys.Drop(id)
+	return ok
+}
+
+// Len returns the number of keys currently in the set.
+func (s *Set) Len() int {
+	if s == nil {
+		return 0
+	}
+	s.μ.Lock()
+	defer s.μ.Unlock()
+	return s.keys.Len()
+}
+
+// Cap returns the maximum number of keys in the set.
+func (s *Set) Cap() int {
+	if s == nil {
+		return 0
+	}
+	s.μ.Lock()
+	defer s.μ.Unlock()
+	return s.keys.Cap()
+}
+
+// Reset removes all keys from s, leaving it empty.  This operation does not
+// change the capacity of s.
+func (s *Set) Reset() {
+	if s != nil {
+		s.μ.Lock()
+		defer s.μ.Unlock()
+		s.keys.Reset()
+	}
+}
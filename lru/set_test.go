@@ -0,0 +1,73 @@
+package lru
+
+import "testing"
+
+func TestSet(t *testing.T) {
+	s := NewSet(3)
+	tests := []struct {
+		op, id string
+		want   bool
+	}{
+		{"+", "a", false}, // add a
+		{"+", "b", false}, // add b
+		{"+", "a", true},  // a already present
+		{"+", "c", false}, // add c
+		{"?", "b", true},  // hit
+		{"+", "d", false}, // evict a
+		{"?", "a", false}, // miss
+		{"?", "c", true},  // hit
+		{"+", "e", false}, // evict b
+		{"?", "b", false}, // miss
+		{"-", "d", true},  // drop hit
+		{"-", "d", false}, // drop miss
+		{"+", "f", false}, // no eviction
+		{"?", "c", true},  // hit
+		{"?", "e", true},  // hit
+		{"?", "f", true},  // hit
+	}
+	for _, test := range tests {
+		var got bool
+		switch test.op {
+		case "+":
+			got = s.Add(test.id)
+		case "?":
+			got = s.Contains(test.id)
+		case "-":
+			got = s.Drop(test.id)
+		default:
+			t.Fatalf("Invalid test: %+v", test)
+		}
+		if got != test.want {
+			t.Errorf("%s %q: got %v, want %v", test.op, test.id, got, test.want)
+		}
+		if n := s.Len(); n > s.Cap() {
+			t.Errorf("Len %d exceeds capacity %d", n, s.Cap())
+		}
+	}
+
+	s.Reset()
+	if n := s.Len(); n != 0 {
+		t.Errorf("Len after Reset: got %d, want 0", n)
+	}
+	if s.Contains("c") {
+		t.Error("Contains(c) after Reset: got true, want false")
+	}
+}
+
+func TestSetEmpties(t *testing.T) {
+	for _, s := range []*Set{nil, NewSet(0), new(Set)} {
+		if n := s.Len(); n != 0 {
+			t.Errorf("Len: got %d, want 0", n)
+		}
+		if s.Add("foo") {
+			t.Error("Add(foo): got true, want false")
+		}
+		if s.Contains("foo") {
+			t.Error("Contains(foo): got true, want false")
+		}
+		if s.Drop("foo") {
+			t.Error("Drop(foo): got true, want false")
+		}
+		s.Reset() // shouldn't crash
+	}
+}
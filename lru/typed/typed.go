@@ -0,0 +1,811 @@
+// Package typed implements a least-recently-used (LRU) cache with typed keys
+// and values.
+//
+// This is the implementation underlying package lru, whose Cache is a typed
+// cache of cache.Value indexed by strings.  Using this package directly, values
+// need not implement cache.Value, and Get returns values of the stored type
+// without a type assertion.
+//
+// Basic usage:
+//   c := typed.New[string, []byte](1<<20, typed.SizeOf[string](func(b []byte) int {
+//      return len(b)
+//   }))
+//   c.Put("x", []byte("hello"))
+//   if v, ok := c.Get("x"); ok {
+//      fmt.Println("x is present:", string(v))
+//   } else {
+//      fmt.Println("x is absent")
+//   }
+//   c.Reset()
+//
+// The cache does not inspect its values: unless the SizeOf option is set, each
+// value has size 1, and values are told of their lifecycle only through the
+// OnAdmit and OnRelease options.  Package lru uses these options to support
+// cache.Value, cache.Admitter and cache.Evicter.
+package typed
+
+import (
+	"fmt"
+	"io"
+	"reflect"
+	"sync"
+)
+
+// Cache implements an LRU cache of values of type V indexed by keys of type
+// K.  A *Cache is safe for concurrent access by multiple goroutines, unless it
+// was created with the Unlocked option.  A nil *Cache behaves as a cache with
+// 0 capacity.
+//
+// The zero value of Cache is ready for use as an empty cache with 0 capacity,
+// which can be given a capacity later with SetCap.
+type Cache[K comparable, V any] struct {
+	μ       sync.Mutex
+	nolock  bool               // if true, μ is not used
+	size    int                // resident size (invariant: size ≤ cap)
+	cap     int                // maximum capacity
+	low     int                // low watermark (≤ 0 means the same as cap)
+	scan    int                // number of LRU entries to consider as victims
+	keep    bool               // whether replacement preserves recency
+	seq     *entry[K, V]       // sentinel for doubly-linked ring
+	res     map[K]*entry[K, V] // resident entries
+	name    string             // optional instance name
+	sizeOf  func(V) int        // if nil, each value has size 1
+	onEvict func(K, V)
+	onAdmit func(V)
+	onFree  func(V)
+	admit   func(K, V) bool
+	valid   func(K, V) bool
+
+	checkSize    bool
+	onSizeChange func(key K, recorded, current int)
+	onBadSize    func(key K, v V)
+	maxSize      int // if positive, the largest size recorded for a value
+
+	closeOnEvict bool
+	onCloseError func(V, error)
+}
+
+// An Option is a configurable setting for a cache.
+type Option[K comparable, V any] func(*Cache[K, V])
+
+// SizeOf sets the function the cache uses to compute the size of each value.
+// By default, each value has size 1, so that the capacity of the cache is a
+// number of entries.
+func SizeOf[K comparable, V any](f func(V) int) Option[K, V] {
+	return func(c *Cache[K, V]) { c.sizeOf = f }
+}
+
+// Name sets the name of the cache.  The name is included in panic messages,
+// to distinguish among the caches of a program.
+func Name[K comparable, V any](name string) Option[K, V] {
+	return func(c *Cache[K, V]) { c.name = name }
+}
+
+// OnEvict causes f to be called with the key and value of each entry that is
+// removed from the cache, including values replaced by Put and removed by Drop
+// or Reset.
+//
+// Calls to f are made synchronously while the cache lock is held, so they are
+// delivered in exactly the order the evictions occur. For the same reason, f
+// must not call methods of the cache.
+func OnEvict[K comparable, V any](f func(K, V)) Option[K, V] {
+	return func(c *Cache[K, V]) { c.onEvict = f }
+}
+
+// OnAdmit causes f to be called with each value after it is stored in the
+// cache.  Storing the value that is already cached under a key does not call
+// f.  The function f is called while the cache lock is held, and must not call
+// methods of the cache.
+func OnAdmit[K comparable, V any](f func(V)) Option[K, V] {
+	return func(c *Cache[K, V]) { c.onAdmit = f }
+}
+
+// OnRelease causes f to be called with each value after it is removed from the
+// cache, whether by eviction, replacement, or a reset (including ResetQuiet).
+// Unlike OnEvict, it is meant for releasing resources held by the value, and
+// it is not called when a value is replaced by itself.  The function f is
+// called while the cache lock is held, after any OnEvict handler, and must not
+// call methods of the cache.
+func OnRelease[K comparable, V any](f func(V)) Option[K, V] {
+	return func(c *Cache[K, V]) { c.onFree = f }
+}
+
+// LowWater sets the low watermark of the cache to n. When a Put must evict
+// values to make room, it evicts until the resident size including the new
+// value is at most n, rather than only until the new value fits.  This trades
+// a larger eviction on one Put for fewer evictions on subsequent ones.  The
+// capacity acts as the high watermark; if n ≤ 0 or n is greater than the
+// capacity, it has no effect.
+func LowWater[K comparable, V any](n int) Option[K, V] {
+	return func(c *Cache[K, V]) { c.low = n }
+}
+
+// KeepRecency causes a Put that replaces the value of an existing entry to
+// leave the recency of that entry unchanged.  By default, replacing a value
+// makes it the most-recently used entry, like inserting a new one.
+func KeepRecency[K comparable, V any]() Option[K, V] {
+	return func(c *Cache[K, V]) { c.keep = true }
+}
+
+// EvictLargest causes the cache to consider the k least-recently used entries
+// when it must choose a victim, and to evict the largest of them, preferring
+// the least-recently used among entries of equal size.  If k ≤ 1, the
+// least-recently used entry is always the victim; this is the default.
+func EvictLargest[K comparable, V any](k int) Option[K, V] {
+	return func(c *Cache[K, V]) { c.scan = k }
+}
+
+// CloseOnEvict causes the cache to call the Close method of each value that
+// implements io.Closer when it is removed from the cache, whether by eviction,
+// replacement, or a reset (including ResetQuiet).  If Close reports an error
+// and onError != nil, onError is called with the value and the error.  Close
+// is called while the cache lock is held, after any OnEvict handler.
+func CloseOnEvict[K comparable, V any](onError func(V, error)) Option[K, V] {
+	return func(c *Cache[K, V]) { c.closeOnEvict = true; c.onCloseError = onError }
+}
+
+// OnNegativeSize causes f to be called with the key and value when a Put or
+// Resize finds a value with a negative size, instead of panicking.  The value
+// is rejected: a Put does not store it, and a Resize evicts it.  The function
+// f must not call methods of the cache.
+func OnNegativeSize[K comparable, V any](f func(key K, v V)) Option[K, V] {
+	return func(c *Cache[K, V]) { c.onBadSize = f }
+}
+
+// ClampSize causes the cache to treat any value whose size is more than n as
+// having size n.  If n ≤ 0, sizes are not clamped; this is the default.
+func ClampSize[K comparable, V any](n int) Option[K, V] {
+	return func(c *Cache[K, V]) { c.maxSize = n }
+}
+
+// Admit causes f to be consulted before a new key is inserted into the cache.
+// If f returns false, the value is not stored.  Replacing the value of a key
+// that is already resident does not consult f.  The function f is called while
+// the cache lock is held, and must not call methods of the cache.
+func Admit[K comparable, V any](f func(key K, v V) bool) Option[K, V] {
+	return func(c *Cache[K, V]) { c.admit = f }
+}
+
+// Validate causes f to be consulted when Get finds a resident value.  If f
+// returns false, the value is evicted and Get reports a miss.  The function f
+// is called while the cache lock is held, and must not call methods of the
+// cache.
+func Validate[K comparable, V any](f func(key K, v V) bool) Option[K, V] {
+	return func(c *Cache[K, V]) { c.valid = f }
+}
+
+// Unlocked disables locking in the cache.  An unlocked *Cache is not safe for
+// concurrent use by multiple goroutines.
+func Unlocked[K comparable, V any]() Option[K, V] {
+	return func(c *Cache[K, V]) { c.nolock = true }
+}
+
+// CheckSize causes the cache to compute the size of each value again when it
+// is evicted, and to compare the result with the size recorded when the value
+// was stored or last resized.  If they differ, f is called with the key of the
+// value and the recorded and current sizes; if f == nil, the cache panics
+// instead.
+func CheckSize[K comparable, V any](f func(key K, recorded, current int)) Option[K, V] {
+	return func(c *Cache[K, V]) { c.checkSize = true; c.onSizeChange = f }
+}
+
+// New returns a new empty cache with the specified capacity.
+func New[K comparable, V any](capacity int, opts ...Option[K, V]) *Cache[K, V] {
+	c := &Cache[K, V]{cap: capacity}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// Put stores value into the cache under the given key, as the most-recently
+// used entry.  If the value is larger than the capacity of the cache, it is
+// not stored.  Storing the value that is already cached under key does not
+// release it: the value is not notified of eviction or admission, and it is
+// not closed.
+func (c *Cache[K, V]) Put(key K, value V) {
+	if c == nil {
+		return
+	}
+	vsize := c.valueSize(value)
+	c.lock()
+	defer c.unlock()
+	if c.cap <= 0 {
+		return // there is no room for any value
+	} else if vsize < 0 {
+		c.badSize(key, value)
+		return
+	} else if vsize > c.cap {
+		return // there is no room for this value no matter what
+	}
+	c.init()
+	old := c.res[key]
+	if old != nil && c.keep {
+		// Replace the value in place, without changing its recency.
+		same := sameValue(old.value, value)
+		c.checkEntrySize(old)
+		c.replaced(key, old.value, value)
+		old.value = value
+		c.setSize(old, vsize)
+		if !same {
+			c.admitted(value)
+		}
+		return
+	}
+	if old == nil && c.admit != nil && !c.admit(key, value) {
+		return // not admitted
+	}
+	e, same := old, false
+	if e != nil {
+		same = sameValue(e.value, value)
+		c.unlink(e)
+		c.replaced(key, e.value, value)
+		e.value = value
+	} else {
+		e = newEntry(key, value)
+	}
+	e.size = vsize
+	if c.size+vsize > c.cap {
+		for c.size > 0 && c.size+vsize > c.lowWater() {
+			vic := c.victim(nil)
+			if vic == c.seq {
+				c.fail("invalid ring structure")
+			}
+			c.evict(vic)
+		}
+	}
+	e.push(c.seq)
+	c.size += vsize
+	c.res[key] = e
+	if !same {
+		c.admitted(value)
+	}
+}
+
+// Drop discards the value stored in the cache for key, if any, and returns
+// the value discarded and whether it was present.
+func (c *Cache[K, V]) Drop(key K) (V, bool) {
+	if c != nil {
+		c.lock()
+		defer c.unlock()
+		if e := c.res[key]; e != nil {
+			c.evict(e)
+			return e.value, true
+		}
+	}
+	var zero V
+	return zero, false
+}
+
+// Rename moves the value stored under oldKey so that it is stored under newKey
+// instead, preserving its position in the eviction order. If a value was
+// already stored under newKey, it is evicted. Rename reports whether a value
+// was stored under oldKey.
+func (c *Cache[K, V]) Rename(oldKey, newKey K) bool {
+	if c != nil {
+		c.lock()
+		defer c.unlock()
+		e := c.res[oldKey]
+		if e == nil {
+			return false
+		} else if oldKey != newKey {
+			if vic := c.res[newKey]; vic != nil {
+				c.evict(vic)
+			}
+			delete(c.res, oldKey)
+			e.key = newKey
+			c.res[newKey] = e
+		}
+		return true
+	}
+	return false
+}
+
+// Resize updates the recorded size of the value stored under key by computing
+// its size again, for values whose size may change while they are cached.  If
+// the value has grown, other values are evicted as needed to make room for it;
+// if it no longer fits in the cache at all, it is evicted.  Resize does not
+// change the recency of the value.  It reports whether key is resident after
+// the update.
+func (c *Cache[K, V]) Resize(key K) bool {
+	if c != nil {
+		c.lock()
+		defer c.unlock()
+		e := c.res[key]
+		if e == nil {
+			return false
+		}
+		vsize := c.valueSize(e.value)
+		if vsize < 0 {
+			c.badSize(key, e.value)
+			c.evict(e)
+			return false
+		} else if vsize > c.cap {
+			c.evict(e)
+			return false
+		}
+		c.setSize(e, vsize)
+		return true
+	}
+	return false
+}
+
+// Get returns the value associated with key in the cache, and reports whether
+// it was present.  If so, the entry becomes the most-recently used.
+func (c *Cache[K, V]) Get(key K) (V, bool) {
+	if c != nil {
+		c.lock()
+		defer c.unlock()
+		if e := c.res[key]; e != nil {
+			if c.valid != nil && !c.valid(key, e.value) {
+				c.evict(e)
+				var zero V
+				return zero, false
+			}
+			if c.seq.next != e {
+				e.pop()
+				e.push(c.seq)
+			}
+			return e.value, true
+		}
+	}
+	var zero V
+	return zero, false
+}
+
+// Info summarizes a single entry in the cache.
+type Info[K comparable] struct {
+	ID   K   // the key of the entry
+	Size int // the recorded size of the value
+}
+
+// Order returns a summary of the entries resident in the cache, in the order
+// they would be evicted, starting with the least-recently used.  It does not
+// change the recency of any entry.
+func (c *Cache[K, V]) Order() []Info[K] {
+	if c == nil {
+		return nil
+	}
+	c.lock()
+	defer c.unlock()
+	if c.seq == nil {
+		return nil
+	}
+	out := make([]Info[K], 0, len(c.res))
+	for e := c.seq.prev; e != c.seq; e = e.prev {
+		out = append(out, Info[K]{ID: e.key, Size: e.size})
+	}
+	return out
+}
+
+// Range calls f with the key and value of each entry resident in the cache, in
+// the order they would be evicted, until f returns false.  It does not change
+// the recency of any entry.  The function f is called while the cache lock is
+// held, and must not call methods of the cache.
+func (c *Cache[K, V]) Range(f func(key K, v V) bool) {
+	if c == nil {
+		return
+	}
+	c.lock()
+	defer c.unlock()
+	if c.seq == nil {
+		return
+	}
+	for e := c.seq.prev; e != c.seq; e = e.prev {
+		if !f(e.key, e.value) {
+			return
+		}
+	}
+}
+
+// Audit computes the size of each resident value and reports the total
+// difference between the current sizes and the sizes recorded by the cache,
+// positive if values have grown and negative if they have shrunk.  Audit does
+// not change the recorded sizes.
+func (c *Cache[K, V]) Audit() (drift int) {
+	if c != nil {
+		c.lock()
+		defer c.unlock()
+		for _, e := range c.res {
+			drift += c.valueSize(e.value) - e.size
+		}
+	}
+	return drift
+}
+
+// Len returns the number of entries resident in the cache.
+func (c *Cache[K, V]) Len() int {
+	if c == nil {
+		return 0
+	}
+	c.lock()
+	defer c.unlock()
+	return len(c.res)
+}
+
+// Size returns the total size of all values currently resident in the cache.
+func (c *Cache[K, V]) Size() int {
+	if c == nil {
+		return 0
+	}
+	c.lock()
+	defer c.unlock()
+	return c.size
+}
+
+// Name returns the name of c, or "" if it has no name.
+func (c *Cache[K, V]) Name() string {
+	if c == nil {
+		return ""
+	}
+	return c.name
+}
+
+// Cap returns the total capacity of the cache.
+func (c *Cache[K, V]) Cap() int {
+	if c == nil {
+		return 0
+	}
+	c.lock()
+	defer c.unlock()
+	return c.cap
+}
+
+// SetCap sets the capacity of c to n, evicting values as necessary to fit
+// within the new capacity.  If n ≤ 0, all values are evicted.  SetCap has no
+// effect on a nil *Cache.
+func (c *Cache[K, V]) SetCap(n int) {
+	if c != nil {
+		c.lock()
+		defer c.unlock()
+		c.cap = n
+		for len(c.res) != 0 && (n <= 0 || c.size > n) {
+			c.evict(c.victim(nil))
+		}
+	}
+}
+
+// Reset removes all data currently stored in c, leaving it empty.  Values are
+// evicted in order, least-recently used first.  This operation does not change
+// the capacity of c.
+func (c *Cache[K, V]) Reset() {
+	if c != nil {
+		c.lock()
+		defer c.unlock()
+		for len(c.res) != 0 {
+			c.evict(c.seq.prev)
+		}
+	}
+}
+
+// Compact reallocates the internal storage of c to fit its current contents.
+// After a large number of values have been evicted, its index may retain the
+// space needed for the peak occupancy; Compact releases that space to the
+// allocator.  It does not change the contents of c.
+func (c *Cache[K, V]) Compact() {
+	if c != nil {
+		c.lock()
+		defer c.unlock()
+		if c.res == nil {
+			return
+		}
+		res := make(map[K]*entry[K, V], len(c.res))
+		for key, e := range c.res {
+			res[key] = e
+		}
+		c.res = res
+	}
+}
+
+// ResetBatched removes all data currently stored in c, like Reset, but in
+// batches of at most batch values, releasing the cache lock between batches so
+// that other operations can proceed.  Values are evicted least-recently used
+// first.
+//
+// After each batch, if progress != nil, it is called with the total number of
+// values evicted so far and the number still resident.  If progress returns
+// false, ResetBatched stops early; calling it again resumes the reset.
+// ResetBatched reports whether the cache was empty when it returned.
+func (c *Cache[K, V]) ResetBatched(batch int, progress func(evicted, remaining int) bool) bool {
+	if c == nil {
+		return true
+	}
+	if batch <= 0 {
+		batch = 1
+	}
+	var evicted int
+	for {
+		c.lock()
+		for i := 0; i < batch && len(c.res) != 0; i++ {
+			c.evict(c.seq.prev)
+			evicted++
+		}
+		remaining := len(c.res)
+		c.unlock()
+		if remaining == 0 {
+			return true
+		} else if progress != nil && !progress(evicted, remaining) {
+			return false
+		}
+	}
+}
+
+// ResetQuiet removes all data currently stored in c, leaving it empty, without
+// calling the eviction handler for the values removed.  The OnRelease handler
+// is still called, and values are still closed if the cache was created with
+// CloseOnEvict.  This operation does not change the capacity of c.
+func (c *Cache[K, V]) ResetQuiet() {
+	if c != nil {
+		c.lock()
+		defer c.unlock()
+		for len(c.res) != 0 {
+			e := c.seq.prev
+			e.pop()
+			delete(c.res, e.key)
+			c.released(e.value)
+		}
+		c.size = 0
+	}
+}
+
+// Purge evicts the fraction frac of the entries currently stored in c, least
+// recently used first, calling the eviction handler for each.  A fraction ≤ 0
+// evicts nothing; a fraction ≥ 1 evicts everything, like Reset.
+func (c *Cache[K, V]) Purge(frac float64) {
+	if c != nil {
+		c.lock()
+		defer c.unlock()
+		n := purgeCount(len(c.res), frac)
+		for i := 0; i < n; i++ {
+			c.evict(c.seq.prev)
+		}
+	}
+}
+
+// purgeCount returns the number of n entries to evict for a Purge of frac.
+func purgeCount(n int, frac float64) int {
+	if frac <= 0 {
+		return 0
+	} else if frac >= 1 {
+		return n
+	}
+	return int(frac * float64(n))
+}
+
+// setSize updates the recorded size of e to n, evicting other entries if
+// necessary to keep the cache within capacity.  Assumes n ≤ c.cap, and that
+// c.μ is held.
+func (c *Cache[K, V]) setSize(e *entry[K, V], n int) {
+	c.size += n - e.size
+	e.size = n
+	if c.size > c.cap {
+		for c.size > e.size && c.size > c.lowWater() {
+			c.evict(c.victim(e))
+		}
+	}
+}
+
+// victim returns the next entry to be evicted from c, ignoring skip.  If no
+// entries are eligible, victim returns c.seq.  Assumes c.μ is held.
+func (c *Cache[K, V]) victim(skip *entry[K, V]) *entry[K, V] {
+	vic := c.seq
+	for i, e := 0, c.seq.prev; e != c.seq && (i == 0 || i < c.scan); e = e.prev {
+		if e == skip {
+			continue
+		} else if vic == c.seq || e.size > vic.size {
+			vic = e
+		}
+		i++
+	}
+	return vic
+}
+
+// unlink removes e from c without notifying the eviction handler or e.value.
+// Assumes c.μ is held.
+func (c *Cache[K, V]) unlink(e *entry[K, V]) {
+	c.checkEntrySize(e) // before any change, in case it panics
+	e.pop()
+	delete(c.res, e.key)
+	c.size -= e.size
+}
+
+// evict removes e from c, notifying the eviction handler and e.value.
+// Assumes c.μ is held.
+func (c *Cache[K, V]) evict(e *entry[K, V]) {
+	c.unlink(e)
+	if c.onEvict != nil {
+		c.onEvict(e.key, e.value)
+	}
+	c.released(e.value)
+}
+
+// admitted notifies the admission handler that v has been stored in c.
+func (c *Cache[K, V]) admitted(v V) {
+	if c.onAdmit != nil {
+		c.onAdmit(v)
+	}
+}
+
+// replaced notifies the eviction handler that old, the value for key, has been
+// replaced by new, and releases old unless it is the same value as new.
+func (c *Cache[K, V]) replaced(key K, old, new V) {
+	if c.onEvict != nil {
+		c.onEvict(key, old)
+	}
+	if !sameValue(old, new) {
+		c.released(old)
+	}
+}
+
+// released notifies the release handler that v has been removed from c, and
+// closes v if c is configured to do so.
+func (c *Cache[K, V]) released(v V) {
+	if c.onFree != nil {
+		c.onFree(v)
+	}
+	if c.closeOnEvict {
+		if cv, ok := any(v).(io.Closer); ok {
+			if err := cv.Close(); err != nil && c.onCloseError != nil {
+				c.onCloseError(v, err)
+			}
+		}
+	}
+}
+
+// sameValue reports whether a and b are the same value.  Unlike a == b, it
+// never panics: values of reference types, such as cache.Bytes, are the same
+// if they share the same underlying storage, and structs, arrays and
+// interfaces are compared element by element on that basis.
+func sameValue[V any](a, b V) bool {
+	return identical(reflect.ValueOf(any(a)), reflect.ValueOf(any(b)))
+}
+
+// identical reports whether x and y are the same value, in the sense of
+// sameValue.
+func identical(x, y reflect.Value) bool {
+	if !x.IsValid() || !y.IsValid() {
+		return x.IsValid() == y.IsValid()
+	} else if x.Type() != y.Type() {
+		return false
+	}
+	switch x.Kind() {
+	case reflect.Bool:
+		return x.Bool() == y.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return x.Int() == y.Int()
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return x.Uint() == y.Uint()
+	case reflect.Float32, reflect.Float64:
+		return x.Float() == y.Float()
+	case reflect.Complex64, reflect.Complex128:
+		return x.Complex() == y.Complex()
+	case reflect.String:
+		return x.String() == y.String()
+	case reflect.Ptr, reflect.Chan, reflect.Map, reflect.Func, reflect.UnsafePointer:
+		return x.Pointer() == y.Pointer()
+	case reflect.Slice:
+		return x.Pointer() == y.Pointer() && x.Len() == y.Len()
+	case reflect.Interface:
+		return identical(x.Elem(), y.Elem())
+	case reflect.Array:
+		for i := 0; i < x.Len(); i++ {
+			if !identical(x.Index(i), y.Index(i)) {
+				return false
+			}
+		}
+		return true
+	case reflect.Struct:
+		for i := 0; i < x.NumField(); i++ {
+			if !identical(x.Field(i), y.Field(i)) {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// init initializes the internal structures of c, if they have not already
+// been initialized.  Assumes c.μ is held or c is not yet shared.
+func (c *Cache[K, V]) init() {
+	if c.res == nil {
+		var zero V
+		c.seq = newEntry(*new(K), zero)
+		c.res = make(map[K]*entry[K, V])
+	}
+}
+
+// lowWater returns the effective low watermark of c.  Assumes c.μ is held.
+func (c *Cache[K, V]) lowWater() int {
+	if c.low <= 0 || c.low > c.cap {
+		return c.cap
+	}
+	return c.low
+}
+
+// valueSize returns the size of v, clamped to the maximum size for c, if any.
+func (c *Cache[K, V]) valueSize(v V) int {
+	n := 1
+	if c.sizeOf != nil {
+		n = c.sizeOf(v)
+	}
+	if c.maxSize > 0 && n > c.maxSize {
+		return c.maxSize
+	}
+	return n
+}
+
+// badSize reports that v, the value for key, has a negative size.  If c has a
+// handler for this case it is called; otherwise badSize panics.
+func (c *Cache[K, V]) badSize(key K, v V) {
+	if c.onBadSize == nil {
+		c.fail("negative value size for %#v", key)
+	}
+	c.onBadSize(key, v)
+}
+
+// fail panics with a message formatted from msg and args, identifying c by
+// its name if it has one.
+func (c *Cache[K, V]) fail(msg string, args ...interface{}) {
+	msg = fmt.Sprintf(msg, args...)
+	if c.name != "" {
+		panic(fmt.Sprintf("lru cache %q: %s", c.name, msg))
+	}
+	panic("lru: " + msg)
+}
+
+// checkEntrySize verifies that the current size of e.value matches its
+// recorded size, if size checking is enabled.
+func (c *Cache[K, V]) checkEntrySize(e *entry[K, V]) {
+	if !c.checkSize {
+		return
+	} else if n := c.valueSize(e.value); n != e.size {
+		if c.onSizeChange == nil {
+			c.fail("size of %#v changed from %d to %d", e.key, e.size, n)
+		}
+		c.onSizeChange(e.key, e.size, n)
+	}
+}
+
+func newEntry[K comparable, V any](key K, value V) *entry[K, V] {
+	e := &entry[K, V]{key: key, value: value}
+	e.next = e
+	e.prev = e
+	return e
+}
+
+// entry represents a node in a doubly-linked ring structure.
+type entry[K comparable, V any] struct {
+	key        K
+	value      V
+	size       int // the size of value when it was recorded
+	prev, next *entry[K, V]
+}
+
+func (e *entry[K, V]) push(after *entry[K, V]) {
+	e.next = after.next
+	e.prev = after
+	e.next.prev = e
+	after.next = e
+}
+
+func (e *entry[K, V]) pop() {
+	e.prev.next = e.next
+	e.next.prev = e.prev
+	e.next = e
+	e.prev = e
+}
+
+// lock acquires the lock on c, unless c is unlocked.
+func (c *Cache[K, V]) lock() {
+	if !c.nolock {
+		c.μ.Lock()
+	}
+}
+
+// unlock releases the lock on c, unless c is unlocked.
+func (c *Cache[K, V]) unlock() {
+	if !c.nolock {
+		c.μ.Unlock()
+	}
+}
@@ -0,0 +1,194 @@
+// Package topk implements a bounded estimator of key frequencies, combining a
+// count-min sketch with a list of the most frequent keys seen.
+//
+// Unlike an LFU cache, which only knows the frequencies of resident keys, a
+// Counter estimates the frequency of every key it has been shown, in fixed
+// space, and tracks the heaviest hitters among them.
+//
+// Basic usage:
+//    c := topk.New(10) // track the top 10 keys
+//    for _, key := range requests {
+//       c.Add(key)
+//    }
+//    for _, e := range c.Top() {
+//       fmt.Println(e.Key, e.Count)
+//    }
+//
+package topk
+
+import (
+	"sort"
+	"sync"
+)
+
+// Counter estimates the frequencies of string keys, and tracks the keys with
+// the highest estimated frequencies.  Estimates never undercount, but may
+// overcount when distinct keys collide in the sketch.  A *Counter is safe for
+// concurrent access by multiple goroutines.  A nil *Counter behaves as a
+// counter that records nothing.
+type Counter struct {
+	μ     sync.Mutex
+	k     int            // maximum number of keys in top
+	width int            // number of counters per row
+	depth int            // number of rows
+	rows  [][]uint64     // count-min sketch, depth × width
+	top   []Entry        // heavy hitters, in no particular order
+	pos   map[string]int // key → index in top
+}
+
+// An Option is a configurable setting for a Counter.
+type Option func(*Counter)
+
+// Width sets the number of counters in each row of the sketch.  Wider rows
+// reduce overcounting due to collisions.  The default is 1024; if n < 1, a
+// width of 1 is used.
+func Width(n int) Option { return func(c *Counter) { c.width = n } }
+
+// Depth sets the number of rows in the sketch.  More rows reduce the chance of
+// a large overcount.  The default is 4; if n < 1, a depth of 1 is used.
+func Depth(n int) Option { return func(c *Counter) { c.depth = n } }
+
+// New returns a new empty Counter that tracks the k most frequent keys.
+func New(k int, opts ...Option) *Counter {
+	c := &Counter{
+		k:     k,
+		width: 1024,
+		depth: 4,
+		pos:   make(map[string]int),
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	if c.width < 1 {
+		c.width = 1
+	}
+	if c.depth < 1 {
+		c.depth = 1
+	}
+	c.rows = make([][]uint64, c.depth)
+	for i := range c.rows {
+		c.rows[i] = make([]uint64, c.width)
+	}
+	return c
+}
+
+// An Entry records the estimated frequency of a key.
+type Entry struct {
+	Key   string
+	Count uint64
+}
+
+// Add records one occurrence of key, and returns its new estimated frequency.
+func (c *Counter) Add(key string) uint64 {
+	if c == nil {
+		return 0
+	}
+	c.μ.Lock()
+	defer c.μ.Unlock()
+	h1, h2 := hash(key)
+	est := ^uint64(0)
+	for i, row := range c.rows {
+		j := (h1 + uint64(i)*h2) % uint64(c.width)
+		row[j]++
+		if row[j] < est {
+			est = row[j]
+		}
+	}
+	c.update(key, est)
+	return est
+}
+
+// Estimate returns the estimated frequency of key, without recording an
+// occurrence of it.
+func (c *Counter) Estimate(key string) uint64 {
+	if c == nil {
+		return 0
+	}
+	c.μ.Lock()
+	defer c.μ.Unlock()
+	h1, h2 := hash(key)
+	est := ^uint64(0)
+	for i, row := range c.rows {
+		if n := row[(h1+uint64(i)*h2)%uint64(c.width)]; n < est {
+			est = n
+		}
+	}
+	return est
+}
+
+// Top returns the tracked keys with the highest estimated frequencies, in
+// decreasing order of frequency.  Keys with equal estimates are ordered
+// lexicographically.
+func (c *Counter) Top() []Entry {
+	if c == nil {
+		return nil
+	}
+	c.μ.Lock()
+	out := make([]Entry, len(c.top))
+	copy(out, c.top)
+	c.μ.Unlock()
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Count == out[j].Count {
+			return out[i].Key < out[j].Key
+		}
+		return out[i].Count > out[j].Count
+	})
+	return out
+}
+
+// Reset discards all recorded occurrences, leaving c empty.
+func (c *Counter) Reset() {
+	if c == nil {
+		return
+	}
+	c.μ.Lock()
+	defer c.μ.Unlock()
+	for _, row := range c.rows {
+		for j := range row {
+			row[j] = 0
+		}
+	}
+	c.top = c.top[:0]
+	c.pos = make(map[string]int)
+}
+
+// update records that key has estimated frequency n, adding it to the heavy
+// hitters if it qualifies.  Assumes c.μ is held.
+func (c *Counter) update(key string, n uint64) {
+	if i, ok := c.pos[key]; ok {
+		c.top[i].Count = n
+		return
+	} else if len(c.top) < c.k {
+		c.pos[key] = len(c.top)
+		c.top = append(c.top, Entry{Key: key, Count: n})
+		return
+	}
+
+	// The list is full; replace its lightest entry if key is heavier.  A linear
+	// scan is adequate since k is expected to be small.
+	min := -1
+	for i, e := range c.top {
+		if min < 0 || e.Count < c.top[min].Count {
+			min = i
+		}
+	}
+	if min >= 0 && n > c.top[min].Count {
+		delete(c.pos, c.top[min].Key)
+		c.top[min] = Entry{Key: key, Count: n}
+		c.pos[key] = min
+	}
+}
+
+// hash returns a 64-bit FNV-1a hash of key, and a second hash derived from it
+// by mixing, used together to choose the index of key in each row.
+func hash(key string) (h1, h2 uint64) {
+	const offset, prime = 14695981039346656037, 1099511628211
+	h1 = offset
+	for i := 0; i < len(key); i++ {
+		h1 = (h1 ^ uint64(key[i])) * prime
+	}
+	h2 = h1 ^ (h1 >> 31)
+	h2 *= 0xbf58476d1ce4e5b9
+	h2 ^= h2 >> 29
+	return h1, h2 | 1
+}
@@ -0,0 +1,89 @@
+package topk
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestCounter(t *testing.T) {
+	c := New(3)
+	freq := map[string]int{"a": 50, "b": 40, "c": 30, "d": 20, "e": 10}
+	for round := 0; round < 50; round++ {
+		for key, n := range freq {
+			if round < n {
+				c.Add(key)
+			}
+		}
+		// Add a lot of one-off keys to make noise.
+		c.Add(fmt.Sprint("noise-", round))
+	}
+
+	for key, n := range freq {
+		if got := c.Estimate(key); got < uint64(n) {
+			t.Errorf("Estimate(%q): got %d, want ≥ %d", key, got, n)
+		}
+	}
+	if got := c.Estimate("nonesuch"); got != 0 {
+		t.Errorf("Estimate(nonesuch): got %d, want 0", got)
+	}
+
+	want := []Entry{{"a", 50}, {"b", 40}, {"c", 30}}
+	if got := c.Top(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Top: got %+v, want %+v", got, want)
+	}
+
+	c.Reset()
+	if got := c.Top(); len(got) != 0 {
+		t.Errorf("Top after Reset: got %+v, want empty", got)
+	}
+	if got := c.Estimate("a"); got != 0 {
+		t.Errorf("Estimate(a) after Reset: got %d, want 0", got)
+	}
+}
+
+func TestOvercount(t *testing.T) {
+	// With a single narrow row, every key collides, so estimates overcount
+	// but never undercount.
+	c := New(2, Width(1), Depth(1))
+	c.Add("x")
+	c.Add("y")
+	if got := c.Add("x"); got != 3 {
+		t.Errorf("Add(x): got %d, want 3", got)
+	}
+	if got := c.Estimate("z"); got != 3 {
+		t.Errorf("Estimate(z): got %d, want 3", got)
+	}
+}
+
+func ExampleCounter() {
+	c := New(2)
+	for _, key := range []string{"x", "y", "x", "z", "x", "y"} {
+		c.Add(key)
+	}
+	for _, e := range c.Top() {
+		fmt.Println(e.Key, e.Count)
+	}
+	// Output:
+	// x 3
+	// y 2
+}
+
+func TestEmpties(t *testing.T) {
+	for _, c := range []*Counter{nil, New(0), New(3, Width(-1), Depth(-1))} {
+		c.Add("x") // shouldn't crash
+		c.Reset()
+		if n := c.Estimate("x"); n != 0 {
+			t.Errorf("Estimate(x): got %d, want 0", n)
+		}
+		if top := c.Top(); len(top) != 0 {
+			t.Errorf("Top: got %+v, want empty", top)
+		}
+	}
+
+	c := New(1, Width(0), Depth(0))
+	c.Add("x")
+	if n := c.Estimate("x"); n != 1 {
+		t.Errorf("Estimate(x): got %d, want 1", n)
+	}
+}
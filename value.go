@@ -1,5 +1,6 @@
 // Package cache defines the common interface shared by caches to represent
-// cached values.
+// cached values, and a Cache type whose replacement policy is selected when it
+// is constructed.
 package cache
 
 // Value defines the required behaviour of a cached value, which is to return
@@ -9,10 +10,28 @@ package cache
 // counts.
 type Value interface {
 	// Size returns a non-negative integer expressing the size of the value.
-	// If a negative value is returned, cache operations will panic.
+	// If a negative value is returned, cache operations will panic, unless
+	// the cache was created with an OnNegativeSize option, in which case the
+	// value is rejected and reported to its handler instead.
 	Size() int
 }
 
+// Admitter is an optional interface that a Value may implement to be notified
+// when it is stored in a cache.  Admitted is called while the cache lock is
+// held, and must not call methods of the cache.
+type Admitter interface {
+	Admitted()
+}
+
+// Evicter is an optional interface that a Value may implement to be notified
+// when it is removed from a cache, whether by eviction, replacement, or a
+// reset.  Evicted is called while the cache lock is held, and must not call
+// methods of the cache.  A value that owns resources can use this to release
+// them without an OnEvict handler.
+type Evicter interface {
+	Evicted()
+}
+
 // String is a convenience wrapper for storing a string as a cache value.
 // Its size is the length of the string in bytes.
 type String string